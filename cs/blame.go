@@ -0,0 +1,265 @@
+// Blame annotation
+//
+// For cleanup work, when a line last changed matters as much as what it says.
+// GraphQL exposes blame on a commit, so we batch it per file the same way as
+// full text and attach the author, date, and short SHA to each line shown.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Blame is slow to compute on GitHub's side. Large batches time out.
+const blameChunkSize = 10
+
+var blameTempl = `
+query {
+{{ range . }}
+	{{printf "b%d" .Idx}}:repository(owner: "{{.Owner}}", name: "{{.Name}}") {
+		object(expression: "{{.Branch}}") {
+			... on Commit {
+				blame(path: "{{.Path}}") {
+					ranges {
+						startingLine
+						endingLine
+						commit {
+							abbreviatedOid
+							committedDate
+							author {
+								name
+								user {
+									login
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+{{ end }}
+}
+`
+
+// blameLine is the last change to a line
+type blameLine struct {
+	sha    string
+	author string
+	date   time.Time
+}
+
+// blameRange is a run of lines sharing the same last change
+type blameRange struct {
+	start, end int
+	blameLine
+}
+
+// blame of a file, sorted by line
+type blame []blameRange
+
+// at returns the last change of a line, with false when it isn't known
+func (b blame) at(lineno int) (blameLine, bool) {
+	i := sort.Search(len(b), func(i int) bool {
+		return b[i].end >= lineno
+	})
+	if i == len(b) || b[i].start > lineno {
+		return blameLine{}, false
+	}
+	return b[i].blameLine, true
+}
+
+// getBlame of files, chunked to keep GitHub from timing out
+func getBlame(client *http.Client, keys []FileKey, defaultBranches map[string]string) (map[FileKey]blame, error) {
+	start := time.Now()
+	defer func() {
+		v("Getting blame took %s", time.Since(start))
+	}()
+
+	blames := map[FileKey]blame{}
+	failed := map[string]error{}
+	for i := 0; i < len(keys); i += blameChunkSize {
+		chunk := keys[i:min(i+blameChunkSize, len(keys))]
+		v("Blame page: %d", i/blameChunkSize)
+		if err := getBlameChunk(client, chunk, defaultBranches, blames, failed); err != nil {
+			return nil, err
+		}
+	}
+	reportFailures("blames", len(keys), failed)
+	return blames, nil
+}
+
+func getBlameChunk(client *http.Client, keys []FileKey, defaultBranches map[string]string, blames map[FileKey]blame, failed map[string]error) error {
+	type tmplData struct {
+		FileKey
+		Branch string
+		Idx    int
+	}
+	data := []tmplData{}
+	queryAliases := map[string]FileKey{}
+	for i, key := range keys {
+		data = append(data, tmplData{key, defaultBranches[key.RepoString()], i})
+		queryAliases[fmt.Sprintf("b%d", i)] = key
+	}
+
+	var query bytes.Buffer
+	t := template.Must(template.New("blame").Parse(blameTempl))
+	if err := t.Execute(&query, data); err != nil {
+		return fmt.Errorf("failed to query blame: %w", err)
+	}
+
+	type gqlResponse map[string]*struct {
+		Object struct {
+			Blame struct {
+				Ranges []struct {
+					StartingLine int
+					EndingLine   int
+					Commit       struct {
+						AbbreviatedOid string
+						CommittedDate  time.Time
+						Author         struct {
+							Name string
+							User struct {
+								Login string
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	var gr gqlResponse
+	aliasErrs, err := postGraphQL(client, query.String(), &gr)
+	if err != nil {
+		return fmt.Errorf("couldn't get blame: %w", err)
+	}
+	for alias, err := range aliasErrs {
+		key := queryAliases[alias]
+		failed[key.String()] = err
+	}
+
+	for alias, repo := range gr {
+		key := queryAliases[alias]
+		if repo == nil || aliasErrs[alias] != nil {
+			continue
+		}
+		var fileBlame blame
+		for _, r := range repo.Object.Blame.Ranges {
+			author := r.Commit.Author.User.Login
+			if author == "" {
+				author = r.Commit.Author.Name
+			}
+			fileBlame = append(fileBlame, blameRange{
+				start: r.StartingLine,
+				end:   r.EndingLine,
+				blameLine: blameLine{
+					sha:    r.Commit.AbbreviatedOid,
+					author: author,
+					date:   r.Commit.CommittedDate,
+				},
+			})
+		}
+		sort.Slice(fileBlame, func(i, j int) bool {
+			return fileBlame[i].start < fileBlame[j].start
+		})
+		blames[key] = fileBlame
+	}
+	return nil
+}
+
+// matchedFiles in the order they're shown
+func matchedFiles(matches []match) []FileKey {
+	keys := []FileKey{}
+	seen := map[FileKey]struct{}{}
+	for _, m := range matches {
+		key := FileKey{Owner: m.owner, Name: m.repo, Path: m.path}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// annotateBlame fills in the last change of each match
+func annotateBlame(matches []match, blames map[FileKey]blame) {
+	for i, m := range matches {
+		if m.excerpt || m.binary {
+			continue
+		}
+		key := FileKey{Owner: m.owner, Name: m.repo, Path: m.path}
+		if line, ok := blames[key].at(m.lineno); ok {
+			matches[i].blame = line
+		}
+	}
+}
+
+// filterByAge drops matches last changed outside of (newerThan, olderThan),
+// along with their context lines
+//
+// Zero times mean no bound. Lines without blame never pass a bound.
+func filterByAge(matches []match, olderThan, newerThan time.Time) []match {
+	type hit struct {
+		key    FileKey
+		lineno int
+	}
+	keep := map[hit]bool{}
+	for _, m := range matches {
+		if m.lineno != m.matchLine {
+			continue
+		}
+		date := m.blame.date
+		ok := !date.IsZero()
+		if !olderThan.IsZero() && !date.Before(olderThan) {
+			ok = false
+		}
+		if !newerThan.IsZero() && !date.After(newerThan) {
+			ok = false
+		}
+		keep[hit{FileKey{Owner: m.owner, Name: m.repo, Path: m.path}, m.lineno}] = ok
+	}
+
+	filtered := []match{}
+	for _, m := range matches {
+		if keep[hit{FileKey{Owner: m.owner, Name: m.repo, Path: m.path}, m.matchLine}] {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+var relativeAgeRe = regexp.MustCompile(`^(\d+)([dwmy])$`)
+
+// parseAge as a point in time, either a date (2006-01-02) or how long before
+// now (90d, 6w, 18m, 2y)
+func parseAge(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	m := relativeAgeRe.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("expected a date like 2006-01-02 or an age like 90d, 6w, 18m, 2y: %s", s)
+	}
+	n, _ := strconv.Atoi(m[1])
+	switch m[2] {
+	case "d":
+		return now.AddDate(0, 0, -n), nil
+	case "w":
+		return now.AddDate(0, 0, -7*n), nil
+	case "m":
+		return now.AddDate(0, -n, 0), nil
+	default:
+		return now.AddDate(-n, 0, 0), nil
+	}
+}
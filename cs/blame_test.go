@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestBlameAt(t *testing.T) {
+	b := blame{
+		{start: 1, end: 3, blameLine: blameLine{sha: "aaa"}},
+		{start: 4, end: 4, blameLine: blameLine{sha: "bbb"}},
+		{start: 5, end: 9, blameLine: blameLine{sha: "ccc"}},
+	}
+
+	td := map[int]string{0: "", 1: "aaa", 3: "aaa", 4: "bbb", 7: "ccc", 10: ""}
+	for lineno, expected := range td {
+		line, ok := b.at(lineno)
+		if ok != (expected != "") || line.sha != expected {
+			t.Errorf("line %d: expected: %q, got: %q (%v)", lineno, expected, line.sha, ok)
+		}
+	}
+}
+
+func TestFilterByAge(t *testing.T) {
+	old := blameLine{sha: "old", date: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}
+	recent := blameLine{sha: "new", date: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)}
+
+	// Each hit has a line of leading context blamed differently from itself
+	matches := []match{
+		{path: "a.go", lineno: 1, matchLine: 2, blame: recent},
+		{path: "a.go", lineno: 2, matchLine: 2, blame: old},
+		{path: "a.go", lineno: 9, matchLine: 10, blame: old},
+		{path: "a.go", lineno: 10, matchLine: 10, blame: recent},
+		{path: "b.go", lineno: 5, matchLine: 5},
+	}
+	linenos := func(ms []match) []int {
+		got := []int{}
+		for _, m := range ms {
+			got = append(got, m.lineno)
+		}
+		return got
+	}
+
+	cutoff := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := linenos(filterByAge(matches, cutoff, time.Time{})); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("older than: expected [1 2], got: %v", got)
+	}
+	if got := linenos(filterByAge(matches, time.Time{}, cutoff)); !slices.Equal(got, []int{9, 10}) {
+		t.Errorf("newer than: expected [9 10], got: %v", got)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	now := time.Date(2022, 10, 15, 12, 0, 0, 0, time.UTC)
+	td := map[string]time.Time{
+		"":           {},
+		"2021-03-04": time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		"90d":        now.AddDate(0, 0, -90),
+		"2w":         now.AddDate(0, 0, -14),
+		"18m":        now.AddDate(0, -18, 0),
+		"2y":         now.AddDate(-2, 0, 0),
+	}
+	for s, expected := range td {
+		got, err := parseAge(s, now)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		} else if !got.Equal(expected) {
+			t.Errorf("%q: expected: %s, got: %s", s, expected, got)
+		}
+	}
+
+	for _, s := range []string{"2y ago", "yesterday", "3h"} {
+		if _, err := parseAge(s, now); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Large and binary files
+//
+// GraphQL gives up on big blobs and returns their text truncated. Those are
+// fetched again from the REST blobs endpoint, as raw bytes rather than base64
+// inside JSON, as long as they're under --max-filesize. Past that, matches
+// are shown from GitHub's own excerpts. Binary files are reported the way
+// grep does it: "Binary file ... matches".
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// errTooBig is returned for blobs over --max-filesize
+var errTooBig = errors.New("file is bigger than --max-filesize")
+
+// blobInfo is what GraphQL says about a blob besides its text
+type blobInfo struct {
+	oid      string
+	byteSize int64
+	binary   bool
+}
+
+// parseSize like 512K, 10M, or 1G into bytes
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size like 512K, 10M, or 1G")
+	}
+	return n * unit, nil
+}
+
+// isBinary the way git and grep decide: a NUL byte near the start
+func isBinary(content string) bool {
+	return strings.IndexByte(content[:min(len(content), 8000)], 0) != -1
+}
+
+// fetchBlob raw, in full, unless it's bigger than max
+func fetchBlob(client *http.Client, key FileKey, oid string, max int64) (string, error) {
+	baseURL := viper.GetString("base_url")
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u := fmt.Sprintf("%srepos/%s/%s/git/blobs/%s", baseURL, key.Owner, key.Name, oid)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github.raw")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", networkError(err)
+	}
+	defer resp.Body.Close()
+	if err := classifyStatus(resp); err != nil {
+		return "", err
+	}
+
+	// Read no more than we'd keep
+	var b strings.Builder
+	n, err := io.Copy(&b, io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return "", networkError(err)
+	}
+	if n > max {
+		return "", errTooBig
+	}
+	return b.String(), nil
+}
+
+// completeTruncated files from the REST API, and notice binary ones
+//
+// Files that stay truncated keep what GraphQL returned. Matches in them are
+// shown from excerpts instead.
+func completeTruncated(client *http.Client, fullText FullText, blobs map[FileKey]blobInfo) {
+	for key, blob := range blobs {
+		switch {
+		case blob.binary:
+			fullText.Binary[key] = true
+			continue
+		case !fullText.Truncated[key]:
+			if isBinary(fullText.Values[key]) {
+				fullText.Binary[key] = true
+			}
+			continue
+		case blob.byteSize > flags.maxFileSize:
+			v("%s is %d bytes, over --max-filesize: not fetching it in full", key.String(), blob.byteSize)
+			continue
+		}
+
+		text, err := fetchBlob(client, key, blob.oid, flags.maxFileSize)
+		if err != nil {
+			v("couldn't fetch all of %s: %v", key.String(), err)
+			continue
+		}
+		v("Fetched all %d bytes of %s", len(text), key.String())
+		delete(fullText.Truncated, key)
+		if isBinary(text) {
+			fullText.Binary[key] = true
+			continue
+		}
+		fullText.Values[key] = text
+	}
+}
+
+// excerpts of a file GitHub matched in, standing in for its content
+//
+// Each ends in a newline, like the lines of a file would.
+func excerpts(tms []TextMatch) string {
+	var b strings.Builder
+	for _, tm := range tms {
+		b.WriteString(strings.TrimSuffix(tm.Fragment, "\n") + "\n")
+	}
+	return b.String()
+}
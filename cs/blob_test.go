@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"0": 0, "512": 512, "512K": 512 << 10, "10m": 10 << 20, "1G": 1 << 30}
+	for s, want := range tests {
+		if got, err := parseSize(s); err != nil || got != want {
+			t.Errorf("%s: expected %d, got: %d (%v)", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"", "M", "-1K", "ten"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestCompleteTruncated(t *testing.T) {
+	blobs := map[string]string{
+		"full":   strings.Repeat("line\n", 100) + "needle\n",
+		"binary": "\x89PNG\x00\x00needle",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/vnd.github.raw" {
+			t.Errorf("expected the raw media type, got: %s", r.Header.Get("Accept"))
+		}
+		oid := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		rw.Write([]byte(blobs[oid]))
+	}))
+	defer srv.Close()
+	prev := viper.Get("base_url")
+	viper.Set("base_url", srv.URL)
+	defer viper.Set("base_url", prev)
+	flags.maxFileSize = 1 << 10
+	defer func() { flags.maxFileSize = 0 }()
+
+	full := FileKey{Owner: "coxley", Name: "codesearch", Path: "full.txt"}
+	binary := FileKey{Owner: "coxley", Name: "codesearch", Path: "logo.png"}
+	tooBig := FileKey{Owner: "coxley", Name: "codesearch", Path: "huge.txt"}
+	graphBinary := FileKey{Owner: "coxley", Name: "codesearch", Path: "font.ttf"}
+	fullText := FullText{
+		Values:    map[FileKey]string{full: "line\n", binary: "", tooBig: "partial"},
+		Truncated: map[FileKey]bool{full: true, binary: true, tooBig: true},
+		Binary:    map[FileKey]bool{},
+	}
+	completeTruncated(http.DefaultClient, fullText, map[FileKey]blobInfo{
+		full:        {oid: "full", byteSize: int64(len(blobs["full"]))},
+		binary:      {oid: "binary", byteSize: int64(len(blobs["binary"]))},
+		tooBig:      {oid: "huge", byteSize: 1 << 20},
+		graphBinary: {oid: "font", byteSize: 10, binary: true},
+	})
+
+	if fullText.Values[full] != blobs["full"] || fullText.Truncated[full] {
+		t.Errorf("expected the whole file, got %d bytes (truncated: %v)", len(fullText.Values[full]), fullText.Truncated[full])
+	}
+	if !fullText.Binary[binary] || !fullText.Binary[graphBinary] {
+		t.Errorf("expected binary files to be noticed, got: %v", fullText.Binary)
+	}
+	if !fullText.Truncated[tooBig] || fullText.Values[tooBig] != "partial" {
+		t.Errorf("expected a file over --max-filesize to stay truncated")
+	}
+}
+
+func TestTruncatedAndBinaryMatches(t *testing.T) {
+	flags.greppable = true
+	defer func() { flags.greppable = false }()
+
+	big := FileKey{Owner: "coxley", Name: "codesearch", Path: "huge.txt"}
+	binary := FileKey{Owner: "coxley", Name: "codesearch", Path: "logo.png"}
+	searchResult := SearchResult{
+		big:    {{Fragment: "some\nneedle here", Indices: [][2]int{{5, 11}}}},
+		binary: {{Fragment: "needle", Indices: [][2]int{{0, 6}}}},
+	}
+	fullText := FullText{
+		Values:    map[FileKey]string{big: "partial", binary: ""},
+		Truncated: map[FileKey]bool{big: true},
+		Binary:    map[FileKey]bool{binary: true},
+	}
+	matches := createMatches(searchResult, fullText, map[string]string{"coxley/codesearch": "main"})
+	if len(matches) != 2 {
+		t.Fatalf("expected a match in each file, got: %+v", matches)
+	}
+
+	out := withStdout(t, func() { printMatches(matches) })
+	want := "coxley/codesearch:huge.txt:?: needle here\nBinary file coxley/codesearch:logo.png matches\n"
+	if out != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out)
+	}
+}
+
+func TestExcerptsWithoutContext(t *testing.T) {
+	flags.context = 2
+	defer func() { flags.context = 0 }()
+
+	big := FileKey{Owner: "coxley", Name: "codesearch", Path: "huge.txt"}
+	binary := FileKey{Owner: "coxley", Name: "codesearch", Path: "logo.png"}
+	searchResult := SearchResult{
+		big: {
+			{Fragment: "some\nneedle here", Indices: [][2]int{{5, 11}}},
+			{Fragment: "far away\nanother needle", Indices: [][2]int{{17, 23}}},
+		},
+		binary: {{Fragment: "needle", Indices: [][2]int{{0, 6}}}},
+	}
+	fullText := FullText{
+		Values:    map[FileKey]string{big: "partial", binary: ""},
+		Truncated: map[FileKey]bool{big: true},
+		Binary:    map[FileKey]bool{binary: true},
+	}
+	branches := map[string]string{"coxley/codesearch": "main"}
+
+	// Other excerpts aren't context, however close they end up
+	matches := createMatches(searchResult, fullText, branches)
+	if len(matches) != 3 {
+		t.Errorf("expected only the matching lines and the binary file, got: %+v", matches)
+	}
+
+	// Neither has lines to compare across runs
+	if found := plainMatches(searchResult, fullText, branches); len(found) != 0 {
+		t.Errorf("expected excerpts and binary files left out, got: %+v", found)
+	}
+}
@@ -0,0 +1,267 @@
+// Conditional requests
+//
+// GitHub doesn't count a '304 Not Modified' against the rate limit. REST
+// responses that come with an ETag or Last-Modified are kept on disk, under
+// 'data_dir', and asked for again with If-None-Match/If-Modified-Since. When
+// nothing changed, the stored response is used and the request was free.
+//
+// GraphQL requests are POSTs and can't be revalidated, so they skip this.
+// Search results change with every push and are paged differently from one
+// search to the next, so they skip it too.
+//
+// Responses that haven't been used in a month are dropped, and the least
+// recently used go first when there's more than cacheMaxSize of them. Results
+// kept for --offline are bounded the same way. 'cs cache clear' drops both.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Responses bigger than this aren't worth keeping
+const maxCachedBody = 1 << 20
+
+// Bounds on what's kept, checked once per run
+const (
+	cacheMaxAge  = 30 * 24 * time.Hour
+	cacheMaxSize = 256 << 20
+)
+
+// Subdirectories of 'data_dir' that grow on their own
+var cacheDirs = []string{"http-cache", "offline"}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage GitHub responses and --offline results kept on disk",
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete every cached GitHub response and --offline result",
+	Args:  cobra.NoArgs,
+	RunE:  executeCacheClear,
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheClearCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+func executeCacheClear(cmd *cobra.Command, args []string) error {
+	for _, sub := range cacheDirs {
+		dir, err := dataDir(sub)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("couldn't clear %s: %w", dir, err)
+		}
+		fmt.Printf("Cleared %s\n", dir)
+	}
+	return nil
+}
+
+var pruneOnce sync.Once
+
+// pruneCaches in 'data_dir', once per run
+func pruneCaches() {
+	pruneOnce.Do(func() {
+		for _, sub := range cacheDirs {
+			if dir, err := dataDir(sub); err == nil {
+				pruneCache(dir, cacheMaxAge, cacheMaxSize)
+			}
+		}
+	})
+}
+
+// cachedResponse on disk, enough to replay it
+type cachedResponse struct {
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+}
+
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(c.Status),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// cacheTransport revalidates GET requests it has a stored response for
+type cacheTransport struct {
+	base http.RoundTripper
+	dir  string
+	// Responses are per token: what one can see, another might not
+	identity string
+	// Fetch everything again, but still store it
+	refresh bool
+}
+
+// newCacheTransport in 'data_dir', or nil if there's nowhere to keep it
+func newCacheTransport(base http.RoundTripper, token string) *cacheTransport {
+	dir, err := dataDir("http-cache")
+	if err != nil {
+		v("not caching responses: %v", err)
+		return nil
+	}
+	pruneCaches()
+	sum := sha256.Sum256([]byte(token))
+	return &cacheTransport{base: base, dir: dir, identity: hex.EncodeToString(sum[:]), refresh: flags.refresh}
+}
+
+func (t *cacheTransport) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{t.identity, req.Method, req.URL.String(), req.Header.Get("Accept")}, "\x00")))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || strings.Contains(req.URL.Path, "/search/") {
+		return t.base.RoundTrip(req)
+	}
+
+	path := t.path(req)
+	var cached cachedResponse
+	if err := loadJSON(path, &cached); err != nil && !errors.Is(err, errNotStored) {
+		v("ignoring cached response for %s: %v", req.URL.Path, err)
+	}
+	stored := cached.Status != 0 && !t.refresh
+
+	send := req
+	if stored {
+		send = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			send.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			send.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(send)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored && resp.StatusCode == http.StatusNotModified {
+		v("Not modified: %s", req.URL.Path)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		// Fresh headers win, like rate limit counts
+		for k, vals := range resp.Header {
+			if k == "Content-Length" {
+				continue
+			}
+			cached.Header[k] = vals
+		}
+		cached.Stored = time.Now()
+		t.store(path, cached)
+		return cached.response(req), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || (resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+	// Big responses, like whole files, stream through instead
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedBody+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, networkError(err)
+	}
+	if len(body) > maxCachedBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	t.store(path, cachedResponse{
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header.Clone(),
+		Body:   body,
+		Stored: time.Now(),
+	})
+	return resp, nil
+}
+
+// store a response, which is only ever a missed saving if it fails
+func (t *cacheTransport) store(path string, c cachedResponse) {
+	if err := saveJSON(path, c); err != nil {
+		v("couldn't cache %s: %v", c.URL, err)
+	}
+}
+
+// pruneCache of entries unused for maxAge, then of the least recently used
+// until the rest fit in maxSize
+//
+// Every use of an entry stores it again, so its modification time is when it
+// was last used.
+func pruneCache(dir string, maxAge time.Duration, maxSize int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		v("couldn't prune %s: %v", dir, err)
+		return
+	}
+
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	files := []entry{}
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, entry{filepath.Join(dir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+
+	var removed int
+	for _, f := range files {
+		if time.Since(f.used) < maxAge && total <= maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			v("couldn't prune %s: %v", f.path, err)
+			continue
+		}
+		total -= f.size
+		removed++
+	}
+	if removed > 0 {
+		v("Pruned %d entries from %s", removed, dir)
+	}
+}
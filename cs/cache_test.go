@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestCacheTransport(t *testing.T) {
+	var requests, notModified int
+	body := "v1"
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := `"` + body + `"`
+		rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(requests))
+		if r.URL.Path == "/uncached" {
+			io.WriteString(rw, body)
+			return
+		}
+		if r.URL.Path == "/big" {
+			rw.Header().Set("ETag", `"big"`)
+			io.WriteString(rw, strings.Repeat("x", maxCachedBody+1))
+			return
+		}
+		rw.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(rw, body)
+	}))
+	defer srv.Close()
+
+	cache := &cacheTransport{base: http.DefaultTransport, dir: t.TempDir(), identity: "test"}
+	client := &http.Client{Transport: cache}
+	get := func(path string) (string, *http.Response) {
+		t.Helper()
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b), resp
+	}
+
+	get("/orgs")
+	got, resp := get("/orgs")
+	if got != "v1" || resp.StatusCode != http.StatusOK || notModified != 1 {
+		t.Errorf("expected the stored response after a 304, got: %d %q (%d not modified)", resp.StatusCode, got, notModified)
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "2" {
+		t.Errorf("expected fresh headers on a revalidated response, got: %v", resp.Header)
+	}
+
+	body = "v2"
+	if got, _ := get("/orgs"); got != "v2" {
+		t.Errorf("expected a changed response to replace the stored one, got: %q", got)
+	}
+
+	cache.refresh = true
+	get("/orgs")
+	if notModified != 1 {
+		t.Errorf("expected --refresh to skip revalidating, got %d not modified", notModified)
+	}
+
+	// Without validators there's nothing to revalidate
+	cache.refresh = false
+	get("/uncached")
+	if got, _ := get("/uncached"); got != "v2" || notModified != 1 {
+		t.Errorf("expected responses without an ETag to go through, got: %q", got)
+	}
+
+	// Nor is there for what's too big to keep, but it still arrives whole
+	if got, _ := get("/big"); len(got) != maxCachedBody+1 {
+		t.Errorf("expected all of a big response, got %d bytes", len(got))
+	}
+	stored := func(path string) error {
+		return loadJSON(cache.path(httptest.NewRequest(http.MethodGet, srv.URL+path, nil)), &cachedResponse{})
+	}
+	if err := stored("/orgs"); err != nil {
+		t.Errorf("expected /orgs to be stored, got: %v", err)
+	}
+	if err := stored("/big"); err != errNotStored {
+		t.Errorf("expected a big response not to be stored, got: %v", err)
+	}
+
+	// Search results are hardly ever asked for the same way twice
+	get("/search/code")
+	if err := stored("/search/code"); err != errNotStored {
+		t.Errorf("expected search results not to be stored, got: %v", err)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	entries := map[string]time.Duration{
+		"stale.json":  -40 * 24 * time.Hour,
+		"old.json":    -3 * time.Hour,
+		"recent.json": -2 * time.Hour,
+		"new.json":    -1 * time.Hour,
+	}
+	for name, age := range entries {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(age), now.Add(age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stale ones go regardless, then the least recently used until it fits
+	pruneCache(dir, cacheMaxAge, 250)
+	left, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(left)
+	want := []string{filepath.Join(dir, "new.json"), filepath.Join(dir, "recent.json")}
+	if !slices.Equal(left, want) {
+		t.Errorf("expected %v to be left, got: %v", want, left)
+	}
+}
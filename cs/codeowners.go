@@ -0,0 +1,178 @@
+// CODEOWNERS resolution
+//
+// When a search turns up hundreds of matches, the next question is who owns
+// them. We fetch each repo's CODEOWNERS alongside file contents and evaluate
+// it the way GitHub does: last matching pattern wins.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// GitHub looks in these places, in this order, and uses the first it finds
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type codeownersRule struct {
+	pattern *regexp.Regexp
+	owners  []string
+}
+
+// codeowners rules of a single repo, in file order
+type codeowners []codeownersRule
+
+// getCodeowners of every repo in the result, keyed by owner/name
+//
+// Repos without a CODEOWNERS file are left out.
+func getCodeowners(client *http.Client, result SearchResult, defaultBranches map[string]string) (map[string]codeowners, error) {
+	candidates := SearchResult{}
+	for key := range result {
+		for _, p := range codeownersPaths {
+			candidates[FileKey{Owner: key.Owner, Name: key.Name, Path: p}] = nil
+		}
+	}
+	fullText, err := fetchFullText(client, candidates, defaultBranches)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := map[string]codeowners{}
+	for key := range result {
+		repo := key.RepoString()
+		if _, ok := rules[repo]; ok {
+			continue
+		}
+		for _, p := range codeownersPaths {
+			content := fullText.Values[FileKey{Owner: key.Owner, Name: key.Name, Path: p}]
+			if content != "" {
+				v("Using %s for %s", p, repo)
+				rules[repo] = parseCodeowners(content)
+				break
+			}
+		}
+	}
+	return rules, nil
+}
+
+func parseCodeowners(content string) codeowners {
+	rules := codeowners{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, "#"); i != -1 && (i == 0 || line[i-1] != '\\') {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// A pattern without owners is valid: it means nobody owns it
+		rules = append(rules, codeownersRule{
+			pattern: codeownersPattern(strings.ReplaceAll(fields[0], `\#`, "#")),
+			owners:  fields[1:],
+		})
+	}
+	return rules
+}
+
+// codeownersPattern translates gitignore-style patterns to a regex
+//
+//   - Leading or inner slashes anchor to the root, otherwise any depth matches
+//   - A trailing slash only matches directories
+//   - 'docs/*' matches files directly in docs, but not deeper
+//   - Anything else matching a directory also matches everything inside it
+func codeownersPattern(p string) *regexp.Regexp {
+	dirOnly := strings.HasSuffix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var re strings.Builder
+	if anchored {
+		re.WriteString("^")
+	} else {
+		re.WriteString("^(?:.*/)?")
+	}
+
+	for i := 0; i < len(p); i++ {
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case p[i] == '*':
+			re.WriteString("[^/]*")
+		case p[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+
+	switch {
+	case dirOnly:
+		re.WriteString("/.*$")
+	case p == "*" || strings.HasSuffix(p, "/*"):
+		re.WriteString("$")
+	default:
+		re.WriteString("(?:/.*)?$")
+	}
+	return regexp.MustCompile(re.String())
+}
+
+// ownersOf a path, with the last matching rule winning
+func (c codeowners) ownersOf(path string) []string {
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].pattern.MatchString(path) {
+			return c[i].owners
+		}
+	}
+	return nil
+}
+
+// annotateOwners fills in the owners of each match
+func annotateOwners(matches []match, rules map[string]codeowners) {
+	for i, m := range matches {
+		matches[i].owners = rules[m.repoString()].ownersOf(m.path)
+	}
+}
+
+// printByOwner prints matches once per owning team, so they can be handed off
+//
+// Files with several owners show up under each of them.
+func printByOwner(matches []match) {
+	groups := map[string][]match{}
+	for _, m := range matches {
+		owners := m.owners
+		if len(owners) == 0 {
+			owners = []string{"(unowned)"}
+		}
+		for _, o := range owners {
+			groups[o] = append(groups[o], m)
+		}
+	}
+
+	names := []string{}
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i > 0 {
+			fmt.Println()
+		}
+		files := map[string]struct{}{}
+		for _, m := range groups[name] {
+			files[m.repoString()+" "+m.path] = struct{}{}
+		}
+		fmt.Println(color.New(color.FgMagenta, color.Bold).Sprintf("%s (%d files)", name, len(files)))
+		printMatches(groups[name])
+	}
+}
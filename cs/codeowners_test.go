@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodeowners(t *testing.T) {
+	rules := parseCodeowners(`
+# Default owners
+*       @org/everyone
+*.js    @org/frontend # inline comment
+/build/logs/ @org/ops
+docs/*  @org/docs
+apps/   @org/apps
+/scripts/**/deploy.sh @org/release
+/vendor/
+`)
+
+	td := map[string]string{
+		"README.md":                      "@org/everyone",
+		"web/app.js":                     "@org/frontend",
+		"build/logs/today.txt":           "@org/ops",
+		"sub/build/logs/today.txt":       "@org/everyone",
+		"docs/getting-started.md":        "@org/docs",
+		"docs/build-app/troubleshoot.md": "@org/everyone",
+		"apps/x/main.go":                 "@org/apps",
+		"nested/apps/x/main.go":          "@org/apps",
+		"apps":                           "@org/everyone",
+		"scripts/deploy.sh":              "@org/release",
+		"scripts/a/b/deploy.sh":          "@org/release",
+		"vendor/lib/x.go":                "",
+	}
+	for path, expected := range td {
+		if got := strings.Join(rules.ownersOf(path), " "); got != expected {
+			t.Errorf("%s: expected: %q, got: %q", path, expected, got)
+		}
+	}
+
+	var none codeowners
+	if owners := none.ownersOf("anything"); owners != nil {
+		t.Errorf("expected no owners without rules, got: %v", owners)
+	}
+}
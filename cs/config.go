@@ -20,10 +20,10 @@ var (
 	token          string
 )
 
-func initConfig() {
+func initConfig() error {
 	if flags.cfgFile != "" {
 		viper.SetConfigFile(flags.cfgFile)
-		return
+		return nil
 	}
 
 	viper.SetConfigName(defaultCfgFile)
@@ -35,42 +35,44 @@ func initConfig() {
 	// or sharing
 	home, err := os.UserHomeDir()
 	if err != nil {
-		fatalf("couldn't determine your home directory: %v", err)
+		return fmt.Errorf("couldn't determine your home directory: %w", err)
 	}
 	viper.SetDefault("token_file", filepath.Join(home, ".codesearch_token"))
+	viper.SetDefault("data_dir", filepath.Join(home, ".codesearch_data"))
 	viper.SetDefault("base_url", "https://api.github.com/")
 
 	if err := viper.ReadInConfig(); err != nil {
-		setupFlow()
+		return setupFlow()
 	}
 
-	migrateToken()
+	return migrateToken()
 }
 
 // The first few commits put the token as-is into the config. Detect this and
 // move for them.
-func migrateToken() {
+func migrateToken() error {
 	token_file := viper.GetString("token_file")
 	raw := viper.GetString("token")
 	if raw == "" {
 		// Extract token from file into memory
 		b, err := ioutil.ReadFile(token_file)
 		if err != nil {
-			token = askForToken()
-			err := writeToken(token)
-			if err != nil {
-				fatalf("failed writing to token_file: %v", err)
+			if token, err = askForToken(); err != nil {
+				return err
+			}
+			if err := writeToken(token); err != nil {
+				return fmt.Errorf("failed writing to token_file: %w", err)
 			}
 			fmt.Println("Saved")
-			return
+			return nil
 		}
 		token = string(b)
-		return
+		return nil
 	}
 
 	if err := writeToken(raw); err != nil {
-		fatalf(
-			"we've tried to move your token into a separate file but failed - can you help us? (err: %v) (dest: %s)",
+		return fmt.Errorf(
+			"we've tried to move your token into a separate file but failed - can you help us? (err: %w) (dest: %s)",
 			err, token_file,
 		)
 	}
@@ -79,13 +81,14 @@ func migrateToken() {
 
 	err := viper.WriteConfig()
 	if err != nil {
-		fatalf("couldn't save config: %v", err)
+		return fmt.Errorf("couldn't save config: %w", err)
 	}
 
 	w(
 		"FYI: We've moved your token into %s. Now you can edit or move your config in peace",
 		token_file,
 	)
+	return nil
 }
 
 func writeToken(s string) error {
@@ -97,13 +100,16 @@ func init() {
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "set-token",
 		Short: "Set a new personal access token to use for talking to GitHub",
-		Run: func(cmd *cobra.Command, args []string) {
-			token = askForToken()
-			err := writeToken(token)
-			if err != nil {
-				fatalf("failed writing to token_file: %v", err)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if token, err = askForToken(); err != nil {
+				return err
+			}
+			if err := writeToken(token); err != nil {
+				return fmt.Errorf("failed writing to token_file: %w", err)
 			}
 			fmt.Println("Saved")
+			return nil
 		},
 	})
 
@@ -113,29 +119,29 @@ func init() {
 Most terminals support ANSI hyperlinks or displaying only the text. Run this command
 if your terminal is an outlier
 		`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			viper.Set("disable_ansi_url", "true")
 			err := viper.WriteConfig()
 			if err != nil {
-				fatalf("couldn't save config: %v", err)
+				return fmt.Errorf("couldn't save config: %w", err)
 			}
 			fmt.Println("Saved")
-			return
+			return nil
 		},
 	})
 
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "set-org",
 		Short: "Scope all searches to be within a GitHub organization",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 1 {
 				viper.Set("org", args[0])
 				err := viper.WriteConfig()
 				if err != nil {
-					fatalf("couldn't save config: %v", err)
+					return fmt.Errorf("couldn't save config: %w", err)
 				}
 				fmt.Println("Saved")
-				return
+				return nil
 			}
 
 			var answer string
@@ -156,17 +162,17 @@ if your terminal is an outlier
 
 				err := viper.WriteConfig()
 				if err != nil {
-					fatalf("couldn't save config: %v", err)
+					return fmt.Errorf("couldn't save config: %w", err)
 				}
 				fmt.Println("Saved")
-				return
+				return nil
 			}
 
 			fmt.Print("What's the org's name?: ")
 			fmt.Scanln(&answer)
 			viper.Set("org", answer)
 			fmt.Println("Saved")
-			return
+			return nil
 		},
 	})
 	rootCmd.AddCommand(&cobra.Command{
@@ -179,7 +185,7 @@ network penalty.
 If you know that all of the repo's in your search scope use a consistent
 default branch, you can skip this step by setting it. (eg: master or main)
 		`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			var answer string
 			fmt.Print("What branch name would you like to set?: ")
 			fmt.Scanln(&answer)
@@ -187,9 +193,10 @@ default branch, you can skip this step by setting it. (eg: master or main)
 			viper.Set("defaultBranch", answer)
 			err := viper.WriteConfig()
 			if err != nil {
-				fatalf("couldn't save config: %v", err)
+				return fmt.Errorf("couldn't save config: %w", err)
 			}
 			fmt.Println("Saved")
+			return nil
 		},
 	})
 	rootCmd.AddCommand(&cobra.Command{
@@ -199,19 +206,20 @@ default branch, you can skip this step by setting it. (eg: master or main)
 If your org has inconsistent default branch names OR you're using codesearch
 across owners, you can unset it here.
 		`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			viper.Set("defaultBranch", "")
 			err := viper.WriteConfig()
 			if err != nil {
-				fatalf("couldn't save config: %v", err)
+				return fmt.Errorf("couldn't save config: %w", err)
 			}
 			fmt.Println("Saved")
+			return nil
 		},
 	})
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "set-base-url",
 		Short: "Control which GitHub instance you talk to by setting the base URL (eg: GitHub Enterprise)",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			var answer string
 			fmt.Print("What base_url name would you like to set?: ")
 			fmt.Scanln(&answer)
@@ -222,9 +230,10 @@ across owners, you can unset it here.
 			viper.Set("base_url", answer)
 			err := viper.WriteConfig()
 			if err != nil {
-				fatalf("couldn't save config: %v", err)
+				return fmt.Errorf("couldn't save config: %w", err)
 			}
 			fmt.Println("Saved")
+			return nil
 		},
 	})
 	rootCmd.AddCommand(&cobra.Command{
@@ -233,13 +242,14 @@ across owners, you can unset it here.
 		Long: `
 Use the default github api endpoint.
 		`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			viper.Set("base_url", "")
 			err := viper.WriteConfig()
 			if err != nil {
-				fatalf("couldn't save config: %v", err)
+				return fmt.Errorf("couldn't save config: %w", err)
 			}
 			fmt.Println("Saved")
+			return nil
 		},
 	})
 }
@@ -261,16 +271,18 @@ func listOrgs() ([]string, error) {
 	return names, nil
 }
 
-func setupFlow() {
-	token = askForToken()
-	err := writeToken(token)
-	if err != nil {
-		fatalf("failed writing to token_file: %v", err)
+func setupFlow() error {
+	var err error
+	if token, err = askForToken(); err != nil {
+		return err
+	}
+	if err := writeToken(token); err != nil {
+		return fmt.Errorf("failed writing to token_file: %w", err)
 	}
 
 	err = viper.SafeWriteConfig()
 	if err != nil {
-		fatalf("couldn't save config: %v", err)
+		return fmt.Errorf("couldn't save config: %w", err)
 	}
 
 	fmt.Println()
@@ -280,10 +292,10 @@ func setupFlow() {
 		color.GreenString("if you'd like\nto scope searches to a specific organization."),
 	)
 
-	os.Exit(0)
+	return errSetupDone
 }
 
-func askForToken() string {
+func askForToken() (string, error) {
 	color.Blue("Welcome to codesearch!")
 	fmt.Println()
 	baseURL := askForBaseURL()
@@ -291,7 +303,7 @@ func askForToken() string {
 
 	u, err := url.Parse(makeGithubSiteURL("/settings/tokens/new"))
 	if err != nil {
-		fatalf("failed making the token URL: %v", err)
+		return "", fmt.Errorf("failed making the token URL: %w", err)
 	}
 	q := u.Query()
 	q.Add("description", "Codesearch")
@@ -311,7 +323,7 @@ smooth.
 	fmt.Print("Paste token here: ")
 	var token string
 	fmt.Scanln(&token)
-	return token
+	return token, nil
 }
 
 func askForBaseURL() string {
@@ -0,0 +1,290 @@
+// TODO/FIXME debt report
+//
+// Cleanup efforts start with "what's the oldest debt, and whose is it?" We
+// find the markers, pull out who and what they reference, and rank them by
+// how long ago blame says they were last touched.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var debtCmd = &cobra.Command{
+	Use:   "debt",
+	Short: "Report TODO, FIXME, XXX, and HACK markers ranked by age",
+	Long: `
+Report TODO, FIXME, XXX, and HACK markers ranked by age
+
+Files with markers are fetched along with blame for every marker line. Ticket
+IDs (ABC-123, #123) and usernames (TODO(name), @name) are pulled out of the
+marker text. The oldest markers come first, grouped by repo or by CODEOWNERS
+owner.
+
+	cs debt -r codesearch
+	cs debt -o myorg --lang go --group-by owner
+	cs debt -o myorg --path internal --format csv
+	`,
+	Args: cobra.NoArgs,
+	RunE: executeDebt,
+}
+
+var debtFlags = struct {
+	format  string
+	groupBy string
+}{}
+
+func init() {
+	debtCmd.Flags().StringVar(&debtFlags.format, "format", "table", "output as table, json, or csv")
+	debtCmd.Flags().StringVar(&debtFlags.groupBy, "group-by", "repo", "group markers by 'repo' or 'owner'")
+	rootCmd.AddCommand(debtCmd)
+}
+
+var debtMarkers = []string{"TODO", "FIXME", "XXX", "HACK"}
+
+var (
+	debtMarkerRe = regexp.MustCompile(`\b(TODO|FIXME|XXX|HACK)\b(?:\(([^)]*)\))?[:\s-]*(.*)`)
+	debtTicketRe = regexp.MustCompile(`\b[A-Z][A-Z0-9]+-\d+\b|(?:^|[\s(])#\d+\b|/issues/\d+`)
+	debtUserRe   = regexp.MustCompile(`(?:^|[\s(])@([\w-]+(?:/[\w-]+)?)`)
+	// Comment endings aren't part of the marker text
+	debtTrailerRe = regexp.MustCompile(`\s*(?:\*/|-->|#})?\s*$`)
+)
+
+// debtItem is one marker and what we know about it
+type debtItem struct {
+	Repo    string    `json:"repo"`
+	Path    string    `json:"path"`
+	Line    int       `json:"line"`
+	Marker  string    `json:"marker"`
+	Text    string    `json:"text"`
+	Tickets []string  `json:"tickets"`
+	Users   []string  `json:"users"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	SHA     string    `json:"sha"`
+	URL     string    `json:"url"`
+
+	key FileKey
+}
+
+type debtGroup struct {
+	Name  string     `json:"name"`
+	Items []debtItem `json:"items"`
+}
+
+func executeDebt(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+	if debtFlags.groupBy != "repo" && debtFlags.groupBy != "owner" {
+		return fmt.Errorf("unsupported --group-by: %s", debtFlags.groupBy)
+	}
+
+	query := makeQuery([]string{strings.Join(debtMarkers, " OR ")})
+	if flags.showQuery {
+		fmt.Println(query)
+		return nil
+	}
+	v("Query: %s", query)
+
+	res, total, err := performCountedSearch(ctx, query, flags.limit)
+	if err != nil {
+		return err
+	}
+	warnIfCapped("", len(res), total)
+	searchResult := coerceResults(res)
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
+	}
+
+	items := []debtItem{}
+	keys := []FileKey{}
+	for key := range searchResult {
+		keys = append(keys, key)
+	}
+	sort.Sort(FileKeys(keys))
+
+	// Only files with markers are worth blaming
+	marked := []FileKey{}
+	for _, key := range keys {
+		found := findDebt(fullText.Values[key])
+		if len(found) > 0 {
+			marked = append(marked, key)
+		}
+		for _, item := range found {
+			item.key = key
+			item.Repo = key.RepoString()
+			item.Path = key.Path
+			item.URL = makeGithubSiteURL(fmt.Sprintf("%s/blob/%s/%s", item.Repo, defaultBranches[item.Repo], key.Path)) + fmt.Sprintf("#L%d", item.Line)
+			items = append(items, item)
+		}
+	}
+
+	blames, err := getBlame(httpClient, marked, defaultBranches)
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		if line, ok := blames[item.key].at(item.Line); ok {
+			items[i].Author = line.author
+			items[i].Date = line.date
+			items[i].SHA = line.sha
+		}
+	}
+
+	groupOf := func(item debtItem) []string { return []string{item.Repo} }
+	if debtFlags.groupBy == "owner" {
+		rules, err := getCodeowners(httpClient, searchResult, defaultBranches)
+		if err != nil {
+			return err
+		}
+		groupOf = func(item debtItem) []string {
+			if owners := rules[item.Repo].ownersOf(item.Path); len(owners) > 0 {
+				return owners
+			}
+			return []string{"(unowned)"}
+		}
+	}
+	groups := groupDebt(items, groupOf)
+
+	switch debtFlags.format {
+	case "json":
+		err = writeJSON(groups)
+	default:
+		now := time.Now()
+		rows := [][]string{}
+		for _, g := range groups {
+			for _, item := range g.Items {
+				location := fmt.Sprintf("%s:%d", item.Path, item.Line)
+				if debtFlags.groupBy == "owner" {
+					location = item.Repo + ":" + location
+				}
+				text := item.Text
+				if debtFlags.format == "table" {
+					text = color.New(color.FgYellow).Sprint(item.Marker) + " " + ansiURL(text, item.URL)
+				}
+				rows = append(rows, []string{
+					g.Name,
+					formatAge(item.Date, now),
+					item.Author,
+					location,
+					strings.Join(append(append([]string{}, item.Tickets...), item.Users...), " "),
+					text,
+				})
+			}
+		}
+		err = writeRows(debtFlags.format, []string{strings.ToUpper(debtFlags.groupBy), "AGE", "AUTHOR", "LOCATION", "REFS", "TEXT"}, rows)
+	}
+	return err
+}
+
+// findDebt markers in a file, one per line at most
+func findDebt(content string) []debtItem {
+	items := []debtItem{}
+	for i, line := range strings.Split(content, "\n") {
+		m := debtMarkerRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		text := debtTrailerRe.ReplaceAllString(m[3], "")
+		item := debtItem{
+			Line:    i + 1,
+			Marker:  m[1],
+			Text:    text,
+			Tickets: []string{},
+			Users:   []string{},
+		}
+
+		// TODO(name) usually means who, but some put tickets there instead
+		for _, ref := range strings.FieldsFunc(m[2], func(r rune) bool { return r == ',' || r == ' ' }) {
+			if debtTicketRe.MatchString(" " + ref) {
+				item.Tickets = append(item.Tickets, strings.TrimSpace(ref))
+			} else {
+				item.Users = append(item.Users, strings.TrimPrefix(ref, "@"))
+			}
+		}
+		for _, t := range debtTicketRe.FindAllString(text, -1) {
+			item.Tickets = append(item.Tickets, strings.TrimLeft(t, " \t("))
+		}
+		for _, u := range debtUserRe.FindAllStringSubmatch(text, -1) {
+			item.Users = append(item.Users, u[1])
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// groupDebt with the oldest items first, in groups ordered by their oldest
+//
+// Items without blame go last.
+func groupDebt(items []debtItem, groupOf func(debtItem) []string) []debtGroup {
+	older := func(a, b debtItem) bool {
+		if a.Date.IsZero() != b.Date.IsZero() {
+			return b.Date.IsZero()
+		}
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	}
+
+	byName := map[string][]debtItem{}
+	for _, item := range items {
+		for _, name := range groupOf(item) {
+			byName[name] = append(byName[name], item)
+		}
+	}
+
+	groups := []debtGroup{}
+	for name, items := range byName {
+		sort.Slice(items, func(i, j int) bool { return older(items[i], items[j]) })
+		groups = append(groups, debtGroup{Name: name, Items: items})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i].Items[0], groups[j].Items[0]
+		if older(a, b) != older(b, a) {
+			return older(a, b)
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// formatAge roughly, in the same units --older-than takes
+func formatAge(date, now time.Time) string {
+	if date.IsZero() {
+		return "?"
+	}
+	days := int(now.Sub(date).Hours() / 24)
+	switch {
+	case days < 60:
+		return fmt.Sprintf("%dd", days)
+	case days < 730:
+		return fmt.Sprintf("%dm", days/30)
+	default:
+		return fmt.Sprintf("%dy", days/365)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestFindDebt(t *testing.T) {
+	content := `package main
+
+// TODO(alice): drop this once PROJ-142 ships
+func old() {}
+
+/* FIXME: see #88 and ask @org/payments */
+# HACK - works around https://github.com/a/b/issues/7
+func todoList() {} // not a marker: todos, TODOS
+// XXX(PROJ-9, bob) racy`
+
+	items := findDebt(content)
+	if len(items) != 4 {
+		t.Fatalf("expected 4 markers, got: %+v", items)
+	}
+
+	td := []struct {
+		line    int
+		marker  string
+		text    string
+		tickets []string
+		users   []string
+	}{
+		{3, "TODO", "drop this once PROJ-142 ships", []string{"PROJ-142"}, []string{"alice"}},
+		{6, "FIXME", "see #88 and ask @org/payments", []string{"#88"}, []string{"org/payments"}},
+		{7, "HACK", "works around https://github.com/a/b/issues/7", []string{"/issues/7"}, []string{}},
+		{9, "XXX", "racy", []string{"PROJ-9"}, []string{"bob"}},
+	}
+	for i, expected := range td {
+		got := items[i]
+		if got.Line != expected.line || got.Marker != expected.marker || got.Text != expected.text {
+			t.Errorf("expected: %d %s %q, got: %d %s %q", expected.line, expected.marker, expected.text, got.Line, got.Marker, got.Text)
+		}
+		if !slices.Equal(got.Tickets, expected.tickets) {
+			t.Errorf("line %d: expected tickets: %v, got: %v", expected.line, expected.tickets, got.Tickets)
+		}
+		if !slices.Equal(got.Users, expected.users) {
+			t.Errorf("line %d: expected users: %v, got: %v", expected.line, expected.users, got.Users)
+		}
+	}
+}
+
+func TestGroupDebt(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	items := []debtItem{
+		{Repo: "a/x", Line: 1, Date: day(5)},
+		{Repo: "a/x", Line: 2},
+		{Repo: "a/y", Line: 3, Date: day(2)},
+		{Repo: "a/x", Line: 4, Date: day(3)},
+	}
+	groups := groupDebt(items, func(item debtItem) []string { return []string{item.Repo} })
+
+	got := [][]int{}
+	names := []string{}
+	for _, g := range groups {
+		names = append(names, g.Name)
+		lines := []int{}
+		for _, item := range g.Items {
+			lines = append(lines, item.Line)
+		}
+		got = append(got, lines)
+	}
+	if !slices.Equal(names, []string{"a/y", "a/x"}) {
+		t.Errorf("expected groups oldest first, got: %v", names)
+	}
+	if !slices.Equal(got[1], []int{4, 1, 2}) {
+		t.Errorf("expected items oldest first and unknown last, got: %v", got[1])
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	now := time.Date(2022, 10, 15, 0, 0, 0, 0, time.UTC)
+	td := map[time.Time]string{
+		{}:                      "?",
+		now.AddDate(0, 0, -12):  "12d",
+		now.AddDate(0, -7, 0):   "7m",
+		now.AddDate(-3, -1, 0):  "3y",
+		now.AddDate(0, 0, -800): "2y",
+	}
+	for date, expected := range td {
+		if got := formatAge(date, now); got != expected {
+			t.Errorf("%s: expected: %s, got: %s", date, expected, got)
+		}
+	}
+}
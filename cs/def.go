@@ -0,0 +1,356 @@
+// Definition search
+//
+// Searching for a name finds every mention. 'cs def' searches for the
+// keywords each language declares things with, then checks the fetched
+// content so only real declarations are shown. Think jump-to-definition, but
+// across every repo in the org.
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+var defCmd = &cobra.Command{
+	Use:   "def [name]",
+	Short: "Find where a function, type, or class is defined rather than every mention",
+	Long: `
+Find where a function, type, or class is defined rather than every mention
+
+Each supported language is searched for its declaration keywords (func, class,
+def, interface, ...) next to the name. Results are validated against the file
+contents so only declarations are shown, ranked by how many each language and
+repo has.
+
+Use --lang to search a single language; it saves a lot of rate limit.
+
+	cs def getDefaultBranches
+	cs def --lang python SearchResult
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeDef,
+}
+
+func init() {
+	rootCmd.AddCommand(defCmd)
+}
+
+// defLang describes how a language declares things
+type defLang struct {
+	// GitHub's name for the language:[name] qualifier
+	name string
+	exts []string
+	// Searched as "[keyword] [name]" phrases
+	keywords []string
+	// Multi-line regexes where %[1]s is the captured name
+	patterns []string
+}
+
+var defLangs = []defLang{
+	{
+		name:     "go",
+		exts:     []string{".go"},
+		keywords: []string{"func", "type"},
+		patterns: []string{
+			`^func[ \t]+(?:\([^)]*\)[ \t]*)?(%[1]s)[ \t]*[\[(]`,
+			`^[ \t]*type[ \t]+(%[1]s)\b`,
+			// Inside a grouped 'type (...)'
+			`^[ \t]+(%[1]s)[ \t]+(?:struct|interface)\b`,
+			`^(?:var|const)[ \t]+(%[1]s)\b`,
+		},
+	},
+	{
+		name:     "python",
+		exts:     []string{".py", ".pyi"},
+		keywords: []string{"def", "class"},
+		patterns: []string{
+			`^[ \t]*(?:async[ \t]+)?def[ \t]+(%[1]s)[ \t]*\(`,
+			`^[ \t]*class[ \t]+(%[1]s)\b`,
+		},
+	},
+	{
+		name:     "typescript",
+		exts:     []string{".ts", ".tsx"},
+		keywords: []string{"function", "class", "interface", "type", "const"},
+		patterns: []string{
+			`\bfunction[ \t]*\*?[ \t]+(%[1]s)[ \t]*[(<]`,
+			`\b(?:class|interface|enum)[ \t]+(%[1]s)\b`,
+			`\btype[ \t]+(%[1]s)[ \t]*[=<]`,
+			`\b(?:const|let|var)[ \t]+(%[1]s)[ \t]*[:=]`,
+		},
+	},
+	{
+		name:     "javascript",
+		exts:     []string{".js", ".jsx", ".mjs", ".cjs"},
+		keywords: []string{"function", "class", "const"},
+		patterns: []string{
+			`\bfunction[ \t]*\*?[ \t]+(%[1]s)[ \t]*\(`,
+			`\bclass[ \t]+(%[1]s)\b`,
+			`\b(?:const|let|var)[ \t]+(%[1]s)[ \t]*=`,
+		},
+	},
+	{
+		name:     "java",
+		exts:     []string{".java"},
+		keywords: []string{"class", "interface", "enum", "void"},
+		patterns: []string{
+			`\b(?:class|interface|enum|record)[ \t]+(%[1]s)\b`,
+			// Return type and modifiers ahead of the name, no semicolon after
+			`^[ \t]*(?:[\w<>\[\],.?]+[ \t]+)+(%[1]s)[ \t]*\([^;{]*\{?[ \t]*$`,
+		},
+	},
+	{
+		name:     "kotlin",
+		exts:     []string{".kt", ".kts"},
+		keywords: []string{"fun", "class", "interface", "object"},
+		patterns: []string{
+			`\bfun[ \t]+(?:<[^>]*>[ \t]*)?(?:[\w.]+\.)?(%[1]s)[ \t]*\(`,
+			`\b(?:class|interface|object|typealias)[ \t]+(%[1]s)\b`,
+		},
+	},
+	{
+		name:     "ruby",
+		exts:     []string{".rb", ".rake"},
+		keywords: []string{"def", "class", "module"},
+		patterns: []string{
+			`^[ \t]*def[ \t]+(?:self\.)?(%[1]s)\b`,
+			`^[ \t]*(?:class|module)[ \t]+(?:[\w:]+::)?(%[1]s)\b`,
+		},
+	},
+	{
+		name:     "rust",
+		exts:     []string{".rs"},
+		keywords: []string{"fn", "struct", "enum", "trait"},
+		patterns: []string{
+			`\bfn[ \t]+(%[1]s)[ \t]*[(<]`,
+			`\b(?:struct|enum|trait|type|mod|union)[ \t]+(%[1]s)\b`,
+		},
+	},
+	{
+		name:     "csharp",
+		exts:     []string{".cs"},
+		keywords: []string{"class", "interface", "struct", "void"},
+		patterns: []string{
+			`\b(?:class|interface|struct|enum|record)[ \t]+(%[1]s)\b`,
+			`^[ \t]*(?:[\w<>\[\],.?]+[ \t]+)+(%[1]s)[ \t]*\([^;{]*\{?[ \t]*$`,
+		},
+	},
+	{
+		name:     "php",
+		exts:     []string{".php"},
+		keywords: []string{"function", "class", "interface", "trait"},
+		patterns: []string{
+			`\bfunction[ \t]+&?(%[1]s)[ \t]*\(`,
+			`\b(?:class|interface|trait|enum)[ \t]+(%[1]s)\b`,
+		},
+	},
+	{
+		name:     "scala",
+		exts:     []string{".scala"},
+		keywords: []string{"def", "class", "object", "trait"},
+		patterns: []string{
+			`\bdef[ \t]+(%[1]s)\b`,
+			`\b(?:class|object|trait)[ \t]+(%[1]s)\b`,
+		},
+	},
+	{
+		name:     "swift",
+		exts:     []string{".swift"},
+		keywords: []string{"func", "class", "struct", "protocol"},
+		patterns: []string{
+			`\bfunc[ \t]+(%[1]s)[ \t]*[(<]`,
+			`\b(?:class|struct|enum|protocol|extension)[ \t]+(%[1]s)\b`,
+		},
+	},
+}
+
+// terms for the search query: "func Foo" OR "type Foo"
+func (d defLang) terms(name string) []string {
+	terms := []string{}
+	for i, kw := range d.keywords {
+		if i > 0 {
+			terms = append(terms, "OR")
+		}
+		terms = append(terms, strconv.Quote(kw+" "+name))
+	}
+	return terms
+}
+
+// defFinder matches declarations of one name in one language
+type defFinder []*regexp.Regexp
+
+// compile the patterns for a name, once for every file they're used on
+func (d defLang) compile(name string) defFinder {
+	f := defFinder{}
+	for _, p := range d.patterns {
+		f = append(f, regexp.MustCompile("(?m)"+fmt.Sprintf(p, regexp.QuoteMeta(name))))
+	}
+	return f
+}
+
+// find byte offsets of the name wherever it's being declared
+func (f defFinder) find(content string) [][2]int {
+	indices := [][2]int{}
+	seen := map[int]struct{}{}
+	for _, re := range f {
+		for _, loc := range re.FindAllStringSubmatchIndex(content, -1) {
+			if _, ok := seen[loc[2]]; ok {
+				continue
+			}
+			seen[loc[2]] = struct{}{}
+			indices = append(indices, [2]int{loc[2], loc[3]})
+		}
+	}
+
+	// createMatches expects indices in the order they appear
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i][0] < indices[j][0]
+	})
+	return indices
+}
+
+func defLangFor(filename string) (defLang, bool) {
+	ext := path.Ext(filename)
+	for _, d := range defLangs {
+		if slices.Contains(d.exts, ext) {
+			return d, true
+		}
+	}
+	return defLang{}, false
+}
+
+func executeDef(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+	name := args[0]
+
+	langs := defLangs
+	if flags.lang != "" {
+		langs = []defLang{}
+		for _, d := range defLangs {
+			if strings.EqualFold(d.name, flags.lang) {
+				langs = append(langs, d)
+			}
+		}
+		if len(langs) == 0 {
+			return fmt.Errorf("no definition patterns for language: %s", flags.lang)
+		}
+	}
+
+	// One search per language; the keywords differ too much to share
+	searchResult := SearchResult{}
+	for _, lang := range langs {
+		terms := lang.terms(name)
+		if flags.lang == "" {
+			terms = append(terms, "language:"+lang.name)
+		}
+		query := makeQuery(terms)
+		if flags.showQuery {
+			fmt.Println(query)
+			continue
+		}
+		v("Query: %s", query)
+
+		res, err := performSearch(ctx, query, flags.limit)
+		if err != nil {
+			return err
+		}
+		for key, tms := range coerceResults(res) {
+			searchResult[key] = tms
+		}
+	}
+	if flags.showQuery || len(searchResult) == 0 {
+		return nil
+	}
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
+	}
+
+	defs := map[FileKey]definitions{}
+	finders := map[string]defFinder{}
+	for key, content := range fullText.Values {
+		lang, ok := defLangFor(key.Path)
+		if !ok {
+			continue
+		}
+		if _, ok := finders[lang.name]; !ok {
+			finders[lang.name] = lang.compile(name)
+		}
+		if indices := finders[lang.name].find(content); len(indices) > 0 {
+			defs[key] = definitions{lang.name, indices}
+		}
+	}
+
+	// --limit is for all definitions, not each file
+	matches := []match{}
+	remaining := flags.limit
+	for _, key := range rankDefinitions(defs) {
+		if flags.limit > 0 && remaining <= 0 {
+			break
+		}
+		result := SearchResult{key: {{Fragment: fullText.Values[key], Indices: defs[key].indices}}}
+		found, shown := createMatchesUpTo(result, fullText, defaultBranches, remaining)
+		matches = append(matches, found...)
+		remaining -= shown
+	}
+	printMatches(matches)
+	return nil
+}
+
+type definitions struct {
+	lang    string
+	indices [][2]int
+}
+
+// rankDefinitions orders files by the language with the most definitions, then
+// by the repo with the most
+//
+// A name declared in a dozen Go repos and one Python script is most likely the
+// Go one you're after.
+func rankDefinitions(defs map[FileKey]definitions) []FileKey {
+	langCounts := map[string]int{}
+	repoCounts := map[string]int{}
+	keys := []FileKey{}
+	for key, d := range defs {
+		langCounts[d.lang] += len(d.indices)
+		repoCounts[d.lang+key.RepoString()] += len(d.indices)
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		li, lj := defs[ki].lang, defs[kj].lang
+		if langCounts[li] != langCounts[lj] {
+			return langCounts[li] > langCounts[lj]
+		}
+		if li != lj {
+			return li < lj
+		}
+		ri, rj := repoCounts[li+ki.RepoString()], repoCounts[lj+kj.RepoString()]
+		if ri != rj {
+			return ri > rj
+		}
+		return FileKeys{ki, kj}.Less(0, 1)
+	})
+	return keys
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFindDefinitions(t *testing.T) {
+	type data struct {
+		filename string
+		content  string
+		expected int
+	}
+
+	td := []data{
+		{"foo.go", "func Foo() {}\nfunc (c *Client) Foo(x int) {}\ntype Foo struct{}\n\nvar x = Foo()\n// Foo does things\n", 3},
+		{"foo.go", "type (\n\tFoo struct{}\n\tBar interface{}\n)\n", 1},
+		{"foo.py", "class Foo:\n    def Foo(self):\n        Foo()\n", 2},
+		{"foo.ts", "export interface Foo {}\nconst Foo = () => 1;\nnew Foo();\n", 2},
+		{"Foo.java", "public class Foo {\n  public static void Foo(int x) {\n    Foo(1);\n  }\n}\n", 2},
+		{"foo.rs", "pub fn Foo<T>() {}\nlet x = Foo();\n", 1},
+	}
+	for _, test := range td {
+		lang, ok := defLangFor(test.filename)
+		if !ok {
+			t.Fatalf("no language for %s", test.filename)
+		}
+		indices := lang.compile("Foo").find(test.content)
+		if len(indices) != test.expected {
+			t.Errorf("%s: expected %d definitions, got: %v", test.filename, test.expected, indices)
+		}
+		for _, idx := range indices {
+			if got := test.content[idx[0]:idx[1]]; got != "Foo" {
+				t.Errorf("%s: expected index to point at Foo, got: %s", test.filename, got)
+			}
+		}
+	}
+}
+
+func TestRankDefinitions(t *testing.T) {
+	defs := map[FileKey]definitions{
+		{Owner: "o", Name: "a", Path: "x.py"}: {"python", [][2]int{{0, 1}}},
+		{Owner: "o", Name: "b", Path: "x.go"}: {"go", [][2]int{{0, 1}}},
+		{Owner: "o", Name: "c", Path: "x.go"}: {"go", [][2]int{{0, 1}, {2, 3}}},
+	}
+	ranked := rankDefinitions(defs)
+	expected := []string{"c", "b", "a"}
+	for i, key := range ranked {
+		if key.Name != expected[i] {
+			t.Errorf("expected %v, got: %v", expected, ranked)
+			break
+		}
+	}
+}
@@ -0,0 +1,450 @@
+// Dependency version drift
+//
+// "Who's still on the old version?" comes up every time a library ships a
+// fix. 'cs deps' searches manifests for a dependency, parses them properly,
+// and shows what each repo declares.
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+	"golang.org/x/mod/modfile"
+)
+
+var depsCmd = &cobra.Command{
+	Use:   "deps MODULE",
+	Short: "Show which version of a dependency each repo declares",
+	Long: `
+Show which version of a dependency each repo declares
+
+Searches go.mod, package.json, requirements*.txt, pyproject.toml, Gemfile, and
+pom.xml files mentioning the dependency and parses them. Repos declaring an
+older version than the newest one seen are highlighted.
+
+Maven dependencies can be given as groupId:artifactId or just the artifactId.
+
+	cs deps github.com/spf13/viper
+	cs deps react --format csv
+	cs deps org.slf4j:slf4j-api
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeDeps,
+}
+
+var depsFlags = struct {
+	format string
+}{}
+
+func init() {
+	depsCmd.Flags().StringVar(&depsFlags.format, "format", "table", "output as table, json, or csv")
+	rootCmd.AddCommand(depsCmd)
+}
+
+// manifest is a kind of file declaring dependencies
+type manifest struct {
+	// Qualifiers narrowing the search to these files
+	qualifiers []string
+	matches    func(filename string) bool
+	// Declared version constraints of the dependency, if any
+	parse func(content, dep string) ([]string, error)
+}
+
+var manifests = []manifest{
+	{
+		qualifiers: []string{"filename:go.mod"},
+		matches:    func(f string) bool { return f == "go.mod" },
+		parse:      parseGoMod,
+	},
+	{
+		qualifiers: []string{"filename:package.json"},
+		matches:    func(f string) bool { return f == "package.json" },
+		parse:      parsePackageJSON,
+	},
+	{
+		qualifiers: []string{"filename:requirements", "extension:txt"},
+		matches: func(f string) bool {
+			return strings.HasPrefix(f, "requirements") && strings.HasSuffix(f, ".txt")
+		},
+		parse: parseRequirements,
+	},
+	{
+		qualifiers: []string{"filename:pyproject.toml"},
+		matches:    func(f string) bool { return f == "pyproject.toml" },
+		parse:      parsePyproject,
+	},
+	{
+		qualifiers: []string{"filename:Gemfile"},
+		matches:    func(f string) bool { return f == "Gemfile" },
+		parse:      parseGemfile,
+	},
+	{
+		qualifiers: []string{"filename:pom.xml"},
+		matches:    func(f string) bool { return f == "pom.xml" },
+		parse:      parsePom,
+	},
+}
+
+func manifestFor(filename string) (manifest, bool) {
+	for _, m := range manifests {
+		if m.matches(path.Base(filename)) {
+			return m, true
+		}
+	}
+	return manifest{}, false
+}
+
+type declaredDep struct {
+	Repo    string `json:"repo"`
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Behind  bool   `json:"behind"`
+}
+
+func executeDeps(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+	dep := args[0]
+
+	// Searching ignores punctuation so the artifact is enough for Maven
+	term := dep
+	if i := strings.LastIndex(dep, ":"); i != -1 {
+		term = dep[i+1:]
+	}
+
+	searchResult := SearchResult{}
+	for _, m := range manifests {
+		query := makeQuery(append([]string{strconv.Quote(term)}, m.qualifiers...))
+		if flags.showQuery {
+			fmt.Println(query)
+			continue
+		}
+		v("Query: %s", query)
+
+		res, err := performSearch(ctx, query, flags.limit)
+		if err != nil {
+			return err
+		}
+		for key, tms := range coerceResults(res) {
+			searchResult[key] = tms
+		}
+	}
+	if flags.showQuery || len(searchResult) == 0 {
+		return nil
+	}
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
+	}
+
+	declared := []declaredDep{}
+	for key, content := range fullText.Values {
+		m, ok := manifestFor(key.Path)
+		if !ok {
+			continue
+		}
+		versions, err := m.parse(content, dep)
+		if err != nil {
+			v("couldn't parse %s: %v", key.String(), err)
+			continue
+		}
+		for _, version := range versions {
+			declared = append(declared, declaredDep{
+				Repo:    key.RepoString(),
+				Path:    key.Path,
+				Version: version,
+			})
+		}
+	}
+
+	newest := markBehind(declared)
+	sort.Slice(declared, func(i, j int) bool {
+		if declared[i].Repo != declared[j].Repo {
+			return declared[i].Repo < declared[j].Repo
+		}
+		return declared[i].Path < declared[j].Path
+	})
+
+	switch depsFlags.format {
+	case "json":
+		err = writeJSON(declared)
+	default:
+		rows := [][]string{}
+		for _, d := range declared {
+			version := d.Version
+			if version == "" {
+				version = "(unpinned)"
+			}
+			if depsFlags.format == "table" && d.Behind {
+				version = color.YellowString(version)
+			} else if depsFlags.format == "table" && extractVersion(d.Version) != "" {
+				version = color.GreenString(version)
+			}
+			rows = append(rows, []string{d.Repo, d.Path, version})
+		}
+		err = writeRows(depsFlags.format, []string{"REPO", "PATH", "VERSION"}, rows)
+	}
+	if err != nil {
+		return err
+	}
+
+	var behind int
+	for _, d := range declared {
+		if d.Behind {
+			behind++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "newest seen: %s, %d of %d declarations behind\n", newest, behind, len(declared))
+	return nil
+}
+
+// markBehind flags declarations older than the newest version seen, which is
+// returned
+//
+// Constraints are reduced to the first version in them: ^1.2.3, >=1.2,<2, and
+// ~> 1.2 are all treated as 1.2-ish. It's a heuristic, but that's also how
+// people read them.
+func markBehind(declared []declaredDep) string {
+	var newest string
+	for _, d := range declared {
+		version := extractVersion(d.Version)
+		if version != "" && (newest == "" || compareVersions(version, newest) > 0) {
+			newest = version
+		}
+	}
+	for i, d := range declared {
+		version := extractVersion(d.Version)
+		declared[i].Behind = version != "" && compareVersions(version, newest) < 0
+	}
+	return newest
+}
+
+var versionRe = regexp.MustCompile(`\d+(?:\.\d+)*`)
+
+func extractVersion(constraint string) string {
+	return versionRe.FindString(constraint)
+}
+
+// compareVersions of dotted numbers, treating missing parts as zero
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var ai, bi int
+		if i < len(as) {
+			ai, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			bi, _ = strconv.Atoi(bs[i])
+		}
+		if ai != bi {
+			if ai < bi {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseGoMod(content, dep string) ([]string, error) {
+	f, err := modfile.ParseLax("go.mod", []byte(content), nil)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, r := range f.Require {
+		if r.Mod.Path == dep {
+			versions = append(versions, r.Mod.Version)
+		}
+	}
+	return versions, nil
+}
+
+func parsePackageJSON(content, dep string) ([]string, error) {
+	var pkg map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &pkg); err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, section := range []string{"dependencies", "devDependencies", "peerDependencies", "optionalDependencies"} {
+		var deps map[string]string
+		if err := json.Unmarshal(pkg[section], &deps); err != nil {
+			continue
+		}
+		if version, ok := deps[dep]; ok {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+var pySeparatorRe = regexp.MustCompile(`[-_.]+`)
+
+// normalizePyName so that Foo_Bar, foo.bar, and foo-bar compare equal (PEP 503)
+func normalizePyName(name string) string {
+	return strings.ToLower(pySeparatorRe.ReplaceAllString(name, "-"))
+}
+
+var pep508Re = regexp.MustCompile(`^\s*([A-Za-z0-9][A-Za-z0-9._-]*)\s*(?:\[[^\]]*\])?\s*([^;#]*)`)
+
+// parsePEP508 splits "requests[socks]>=2.0; python_version>'3'" into name and
+// version constraint
+func parsePEP508(s string) (string, string, bool) {
+	m := pep508Re.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], strings.TrimSpace(m[2]), true
+}
+
+func parseRequirements(content, dep string) ([]string, error) {
+	versions := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		// Options like -r other.txt and -e git+https://... aren't dependencies
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		name, version, ok := parsePEP508(line)
+		if ok && normalizePyName(name) == normalizePyName(dep) {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+func parsePyproject(content, dep string) ([]string, error) {
+	var pyproject struct {
+		Project struct {
+			Dependencies         []string
+			OptionalDependencies map[string][]string `toml:"optional-dependencies"`
+		}
+		Tool struct {
+			Poetry struct {
+				Dependencies    map[string]any
+				DevDependencies map[string]any `toml:"dev-dependencies"`
+			}
+		}
+	}
+	if err := toml.Unmarshal([]byte(content), &pyproject); err != nil {
+		return nil, err
+	}
+
+	want := normalizePyName(dep)
+	versions := []string{}
+	pep508 := pyproject.Project.Dependencies
+	for _, deps := range pyproject.Project.OptionalDependencies {
+		pep508 = append(pep508, deps...)
+	}
+	for _, s := range pep508 {
+		if name, version, ok := parsePEP508(s); ok && normalizePyName(name) == want {
+			versions = append(versions, version)
+		}
+	}
+
+	// Poetry uses either "^1.2" or { version = "^1.2", extras = [...] }
+	for _, deps := range []map[string]any{pyproject.Tool.Poetry.Dependencies, pyproject.Tool.Poetry.DevDependencies} {
+		for name, spec := range deps {
+			if normalizePyName(name) != want {
+				continue
+			}
+			switch s := spec.(type) {
+			case string:
+				versions = append(versions, s)
+			case map[string]any:
+				version, _ := s["version"].(string)
+				versions = append(versions, version)
+			}
+		}
+	}
+	return versions, nil
+}
+
+var gemRe = regexp.MustCompile(`^\s*gem\s+['"]([^'"]+)['"]((?:\s*,\s*['"][^'"]*['"])*)`)
+
+func parseGemfile(content, dep string) ([]string, error) {
+	versions := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		m := gemRe.FindStringSubmatch(line)
+		if m == nil || m[1] != dep {
+			continue
+		}
+		// gem 'rails', '>= 6.0', '< 8' has any number of constraints
+		constraints := []string{}
+		for _, c := range strings.Split(m[2], ",") {
+			if c = strings.Trim(strings.TrimSpace(c), `'"`); c != "" {
+				constraints = append(constraints, c)
+			}
+		}
+		versions = append(versions, strings.Join(constraints, ", "))
+	}
+	return versions, nil
+}
+
+func parsePom(content, dep string) ([]string, error) {
+	type pomDep struct {
+		GroupID    string `xml:"groupId"`
+		ArtifactID string `xml:"artifactId"`
+		Version    string `xml:"version"`
+	}
+	var pom struct {
+		Properties struct {
+			Values []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"properties"`
+		Dependencies         []pomDep `xml:"dependencies>dependency"`
+		DependencyManagement []pomDep `xml:"dependencyManagement>dependencies>dependency"`
+	}
+	if err := xml.Unmarshal([]byte(content), &pom); err != nil {
+		return nil, err
+	}
+
+	props := map[string]string{}
+	for _, p := range pom.Properties.Values {
+		props[p.XMLName.Local] = strings.TrimSpace(p.Value)
+	}
+
+	group, artifact := "", dep
+	if i := strings.LastIndex(dep, ":"); i != -1 {
+		group, artifact = dep[:i], dep[i+1:]
+	}
+
+	versions := []string{}
+	for _, d := range append(pom.Dependencies, pom.DependencyManagement...) {
+		if d.ArtifactID != artifact || (group != "" && d.GroupID != group) {
+			continue
+		}
+		version := strings.TrimSpace(d.Version)
+		// ${slf4j.version}
+		if strings.HasPrefix(version, "${") && strings.HasSuffix(version, "}") {
+			if resolved, ok := props[version[2:len(version)-1]]; ok {
+				version = resolved
+			}
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
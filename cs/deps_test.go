@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestManifestParsers(t *testing.T) {
+	type data struct {
+		filename string
+		content  string
+		dep      string
+		expected []string
+	}
+
+	td := []data{
+		{"go.mod", "module foo\n\ngo 1.18\n\nrequire (\n\tgithub.com/spf13/viper v1.13.0\n\tgithub.com/spf13/cobra v1.5.0 // indirect\n)\n", "github.com/spf13/viper", []string{"v1.13.0"}},
+		{"package.json", `{"dependencies": {"react": "^18.2.0"}, "devDependencies": {"react": "17"}, "name": "x"}`, "react", []string{"^18.2.0", "17"}},
+		{"requirements-dev.txt", "# pinned\nRequests[socks]==2.28.1 ; python_version > '3'\n-r base.txt\nflask\n", "requests", []string{"==2.28.1"}},
+		{"requirements.txt", "flask\n", "flask", []string{""}},
+		{"pyproject.toml", "[project]\ndependencies = [\"requests>=2.0\"]\n\n[tool.poetry.dependencies]\nRequests = { version = \"^2.1\" }\n", "requests", []string{">=2.0", "^2.1"}},
+		{"Gemfile", "source 'https://rubygems.org'\ngem 'rails', '>= 6.0', '< 8'\ngem \"pg\"\n", "rails", []string{">= 6.0, < 8"}},
+		{"pom.xml", "<project><properties><slf4j.version>1.7.36</slf4j.version></properties><dependencies><dependency><groupId>org.slf4j</groupId><artifactId>slf4j-api</artifactId><version>${slf4j.version}</version></dependency></dependencies></project>", "org.slf4j:slf4j-api", []string{"1.7.36"}},
+	}
+	for _, test := range td {
+		m, ok := manifestFor(test.filename)
+		if !ok {
+			t.Fatalf("no manifest for %s", test.filename)
+		}
+		versions, err := m.parse(test.content, test.dep)
+		if err != nil {
+			t.Errorf("%s: %v", test.filename, err)
+			continue
+		}
+		if slices.Compare(versions, test.expected) != 0 {
+			t.Errorf("%s: expected: %q, got: %q", test.filename, test.expected, versions)
+		}
+	}
+}
+
+func TestMarkBehind(t *testing.T) {
+	declared := []declaredDep{{Version: "v1.13.0"}, {Version: "^1.9"}, {Version: ""}, {Version: "1.13"}}
+	if newest := markBehind(declared); newest != "1.13.0" {
+		t.Errorf("expected newest to be 1.13.0, got: %s", newest)
+	}
+	for i, expected := range []bool{false, true, false, false} {
+		if declared[i].Behind != expected {
+			t.Errorf("%s: expected behind to be %v", declared[i].Version, expected)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Diffing result sets
+//
+// Two questions come up during migrations: "what changed since last time?"
+// and "what's different between the old and new home?" Both are answered by
+// lining up matching lines by content rather than by line number, so code
+// that merely moved doesn't look like churn.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff OLD.json NEW.json | diff [terms] --from SCOPE --to SCOPE",
+	Short: "Compare two snapshots, or the same search across two scopes",
+	Long: `
+Compare two snapshots, or the same search across two scopes
+
+Snapshots come from --save-snapshot. Scopes are search qualifiers added to the
+query for each side. Lines are matched by content within each file, and lines
+that were edited rather than added or removed show as changed.
+
+When each side is one repo, files are lined up by path alone. When the sides
+are different orgs, repos are lined up by name. Useful for forks and moves.
+A scope with its own org:, user:, or repo: replaces the configured org and
+--repo for that side.
+
+	cs diff last-week.json today.json
+	cs diff OldClient --from org:old-org --to org:new-org
+	cs diff OldClient --from repo:old-org/billing --to repo:new-org/payments
+	`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: executeDiff,
+}
+
+var diffFlags = struct {
+	from   string
+	to     string
+	format string
+}{}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffFlags.from, "from", "", "qualifiers for the old side, like org:old-org")
+	diffCmd.Flags().StringVar(&diffFlags.to, "to", "", "qualifiers for the new side, like org:new-org")
+	diffCmd.Flags().StringVar(&diffFlags.format, "format", "text", "output as text or json")
+	rootCmd.AddCommand(diffCmd)
+}
+
+const (
+	lineAdded   = "added"
+	lineRemoved = "removed"
+	lineChanged = "changed"
+)
+
+// Lines at least this similar are considered edits of each other
+const changedSimilarity = 0.6
+
+type lineChange struct {
+	Kind string      `json:"kind"`
+	Old  *watchMatch `json:"old,omitempty"`
+	New  *watchMatch `json:"new,omitempty"`
+}
+
+type fileDiff struct {
+	File    string       `json:"file"`
+	Changes []lineChange `json:"changes"`
+}
+
+func executeDiff(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	if diffFlags.format != "text" && diffFlags.format != "json" {
+		return fmt.Errorf("unknown format: %s", diffFlags.format)
+	}
+
+	var old, cur savedRun
+	if diffFlags.from == "" && diffFlags.to == "" {
+		if len(args) != 2 {
+			return errors.New("expected two snapshots, or search terms with --from and --to")
+		}
+		var err error
+		if old, err = snapshotRun(args[0]); err != nil {
+			return err
+		}
+		if cur, err = snapshotRun(args[1]); err != nil {
+			return err
+		}
+	} else {
+		if diffFlags.from == "" || diffFlags.to == "" {
+			return errors.New("--from and --to go together")
+		}
+		from, to := sideQuery(args, diffFlags.from), sideQuery(args, diffFlags.to)
+		if flags.showQuery {
+			fmt.Println(from)
+			fmt.Println(to)
+			return nil
+		}
+
+		var err error
+		if old, err = runSavedSearch(cmd.Context(), savedSearch{Name: "--from", Query: from, Limit: flags.limit}); err != nil {
+			return err
+		}
+		if cur, err = runSavedSearch(cmd.Context(), savedSearch{Name: "--to", Query: to, Limit: flags.limit}); err != nil {
+			return err
+		}
+	}
+
+	diffs := diffRuns(old, cur)
+	if diffFlags.format == "json" {
+		if err := writeJSON(diffs); err != nil {
+			return err
+		}
+		return nil
+	}
+	printDiffs(diffs)
+	return nil
+}
+
+var scopeQualifier = regexp.MustCompile(`(?:^|\s)-?(?:org|user|repo):`)
+
+// sideQuery is the terms plus one side's scope
+//
+// A scope that picks its own org or repo replaces the configured org and
+// --repo rather than being narrowed by them.
+func sideQuery(args []string, scope string) string {
+	org, repo := viper.GetString("org"), flags.repo
+	if scopeQualifier.MatchString(scope) {
+		org, repo = "", ""
+	}
+	return strings.TrimSpace(makeQueryIn(args, org, repo)) + " " + scope
+}
+
+func snapshotRun(filename string) (savedRun, error) {
+	snap, err := loadSnapshot(filename)
+	if err != nil {
+		return savedRun{}, err
+	}
+	searchResult, defaultBranches, fullText := snap.unpack()
+	// Everything in the snapshot was already fetched
+	return newSavedRun(filename, searchResult, fullText, defaultBranches, len(searchResult)), nil
+}
+
+// diffRuns like diffMatches, leaving out files either side can't vouch for
+//
+// A file one side couldn't read line by line would show every line of the
+// other side as added or removed. A file only on one side is only added or
+// removed if the other side wasn't capped, like confirmRemoved.
+func diffRuns(old, cur savedRun) []fileDiff {
+	ident := fileIdentity(old.matches, cur.matches)
+	identOf := func(file string) string {
+		repo, path, _ := strings.Cut(file, " ")
+		return ident(watchMatch{Repo: repo, Path: path})
+	}
+	unreadable := map[string]bool{}
+	for _, run := range []savedRun{old, cur} {
+		for file := range run.failed {
+			unreadable[identOf(file)] = true
+		}
+	}
+	seen := [2]map[string]bool{{}, {}}
+	for i, run := range []savedRun{old, cur} {
+		for _, m := range run.matches {
+			seen[i][ident(m)] = true
+		}
+	}
+
+	var skippedUnreadable, skippedAdded, skippedRemoved int
+	keep := func(side int, ms []watchMatch) []watchMatch {
+		kept := []watchMatch{}
+		for _, m := range ms {
+			id := ident(m)
+			switch {
+			case unreadable[id]:
+				skippedUnreadable++
+			case side == 0 && cur.capped && !seen[1][id]:
+				skippedRemoved++
+			case side == 1 && old.capped && !seen[0][id]:
+				skippedAdded++
+			default:
+				kept = append(kept, m)
+			}
+		}
+		return kept
+	}
+	left, right := keep(0, old.matches), keep(1, cur.matches)
+
+	if skippedUnreadable > 0 {
+		w("%d matches are in files that couldn't be read line by line on one side: not comparing them", skippedUnreadable)
+	}
+	if skippedRemoved > 0 {
+		w("%s: results were capped, so %d matches in files it didn't see aren't reported as removed", cur.name, skippedRemoved)
+	}
+	if skippedAdded > 0 {
+		w("%s: results were capped, so %d matches in files it didn't see aren't reported as added", old.name, skippedAdded)
+	}
+	return diffMatchesBy(left, right, ident)
+}
+
+// fileIdentity decides how files on either side are lined up
+//
+// One different repo per side means paths are enough. One different owner per
+// side means repo names and paths. Otherwise everything has to match.
+func fileIdentity(left, right []watchMatch) func(watchMatch) string {
+	owner := func(m watchMatch) string { return strings.SplitN(m.Repo, "/", 2)[0] }
+	only := func(ms []watchMatch, of func(watchMatch) string) (string, bool) {
+		seen := map[string]struct{}{}
+		for _, m := range ms {
+			seen[of(m)] = struct{}{}
+		}
+		for s := range seen {
+			return s, len(seen) == 1
+		}
+		return "", false
+	}
+	repo := func(m watchMatch) string { return m.Repo }
+
+	leftRepo, oneLeft := only(left, repo)
+	rightRepo, oneRight := only(right, repo)
+	if oneLeft && oneRight && leftRepo != rightRepo {
+		return func(m watchMatch) string { return m.Path }
+	}
+	leftOwner, oneLeft := only(left, owner)
+	rightOwner, oneRight := only(right, owner)
+	if oneLeft && oneRight && leftOwner != rightOwner {
+		return func(m watchMatch) string {
+			return strings.SplitN(m.Repo, "/", 2)[1] + ":" + m.Path
+		}
+	}
+	return func(m watchMatch) string { return m.Repo + ":" + m.Path }
+}
+
+// diffMatches per file, by content
+func diffMatches(left, right []watchMatch) []fileDiff {
+	return diffMatchesBy(left, right, fileIdentity(left, right))
+}
+
+// diffMatchesBy a fileIdentity picked beforehand
+func diffMatchesBy(left, right []watchMatch, ident func(watchMatch) string) []fileDiff {
+	byFile := map[string][2][]watchMatch{}
+	for _, m := range left {
+		sides := byFile[ident(m)]
+		sides[0] = append(sides[0], m)
+		byFile[ident(m)] = sides
+	}
+	for _, m := range right {
+		sides := byFile[ident(m)]
+		sides[1] = append(sides[1], m)
+		byFile[ident(m)] = sides
+	}
+
+	files := []string{}
+	for file := range byFile {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	diffs := []fileDiff{}
+	for _, file := range files {
+		sides := byFile[file]
+		if changes := diffLines(sides[0], sides[1]); len(changes) > 0 {
+			diffs = append(diffs, fileDiff{File: file, Changes: changes})
+		}
+	}
+	return diffs
+}
+
+// diffLines of one file, pairing identical lines first and then similar ones
+func diffLines(old, cur []watchMatch) []lineChange {
+	unpairedOld := map[string][]int{}
+	for i, m := range old {
+		key := strings.TrimSpace(m.Text)
+		unpairedOld[key] = append(unpairedOld[key], i)
+	}
+	removedIdx := map[int]bool{}
+	for i := range old {
+		removedIdx[i] = true
+	}
+
+	added := []watchMatch{}
+	for _, m := range cur {
+		key := strings.TrimSpace(m.Text)
+		if indices := unpairedOld[key]; len(indices) > 0 {
+			removedIdx[indices[0]] = false
+			unpairedOld[key] = indices[1:]
+			continue
+		}
+		added = append(added, m)
+	}
+	removed := []watchMatch{}
+	for i, m := range old {
+		if removedIdx[i] {
+			removed = append(removed, m)
+		}
+	}
+
+	changes := []lineChange{}
+	used := make([]bool, len(added))
+	for i := range removed {
+		r := &removed[i]
+		best, bestScore := -1, 0.0
+		for j := range added {
+			score := similarity(strings.TrimSpace(r.Text), strings.TrimSpace(added[j].Text))
+			if used[j] || score < changedSimilarity {
+				continue
+			}
+			// Ties go to the closest line
+			if best == -1 || score > bestScore || (score == bestScore && distance(r.Line, added[j].Line) < distance(r.Line, added[best].Line)) {
+				best, bestScore = j, score
+			}
+		}
+		if best == -1 {
+			changes = append(changes, lineChange{Kind: lineRemoved, Old: r})
+			continue
+		}
+		used[best] = true
+		changes = append(changes, lineChange{Kind: lineChanged, Old: r, New: &added[best]})
+	}
+	for j := range added {
+		if !used[j] {
+			changes = append(changes, lineChange{Kind: lineAdded, New: &added[j]})
+		}
+	}
+
+	line := func(c lineChange) int {
+		if c.New != nil {
+			return c.New.Line
+		}
+		return c.Old.Line
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return line(changes[i]) < line(changes[j])
+	})
+	return changes
+}
+
+func distance(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// similarity of two lines from 0 to 1, like Python's difflib ratio
+//
+// Lines are capped in length so minified files don't take forever.
+func similarity(a, b string) float64 {
+	const maxLen = 500
+	a, b = a[:min(len(a), maxLen)], b[:min(len(b), maxLen)]
+	if len(a)+len(b) == 0 {
+		return 1
+	}
+
+	// Longest common subsequence, keeping only the previous row
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				cur[j] = prev[j-1] + 1
+			} else {
+				cur[j] = max(prev[j], cur[j-1])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return 2 * float64(prev[len(b)]) / float64(len(a)+len(b))
+}
+
+func printDiffs(diffs []fileDiff) {
+	var added, removed, changed int
+	for i, d := range diffs {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(color.New(color.FgBlue, color.Bold).Sprint(d.File))
+		for _, c := range d.Changes {
+			switch c.Kind {
+			case lineAdded:
+				added++
+				fmt.Printf("%s %s: %s\n", color.GreenString("+"), color.GreenString(fmt.Sprint(c.New.Line)), strings.TrimSpace(c.New.Text))
+			case lineRemoved:
+				removed++
+				fmt.Printf("%s %s: %s\n", color.RedString("-"), color.RedString(fmt.Sprint(c.Old.Line)), strings.TrimSpace(c.Old.Text))
+			case lineChanged:
+				changed++
+				fmt.Printf("%s %s: %s\n", color.YellowString("~"), color.RedString(fmt.Sprint(c.Old.Line)), strings.TrimSpace(c.Old.Text))
+				fmt.Printf("  %s: %s\n", color.GreenString(fmt.Sprint(c.New.Line)), strings.TrimSpace(c.New.Text))
+			}
+		}
+	}
+	if len(diffs) > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d files: %d added, %d removed, %d changed\n", len(diffs), added, removed, changed)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSimilarity(t *testing.T) {
+	td := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"", "", 1},
+		{"abc", "abc", 1},
+		{"abc", "xyz", 0},
+		{"OldClient()", "NewClient()", 16.0 / 22},
+	}
+	for _, tc := range td {
+		if got := similarity(tc.a, tc.b); got != tc.expected {
+			t.Errorf("%q vs %q: expected: %v, got: %v", tc.a, tc.b, tc.expected, got)
+		}
+	}
+}
+
+func TestDiffMatches(t *testing.T) {
+	left := []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 10, Text: "c := OldClient()"},
+		{Repo: "a/x", Path: "main.go", Line: 20, Text: "defer OldClient().Close()"},
+		{Repo: "a/x", Path: "main.go", Line: 30, Text: "// OldClient is deprecated"},
+		{Repo: "a/y", Path: "lib.go", Line: 3, Text: "var _ = OldClient"},
+	}
+	right := []watchMatch{
+		// Shifted down, otherwise the same
+		{Repo: "a/x", Path: "main.go", Line: 14, Text: "  c := OldClient()"},
+		{Repo: "a/x", Path: "main.go", Line: 24, Text: "defer OldClient(ctx).Close()"},
+		{Repo: "a/x", Path: "main.go", Line: 50, Text: "x := OldClient()"},
+		{Repo: "a/y", Path: "lib.go", Line: 3, Text: "var _ = OldClient"},
+	}
+
+	diffs := diffMatches(left, right)
+	if len(diffs) != 1 || diffs[0].File != "a/x:main.go" {
+		t.Fatalf("expected only a/x:main.go to differ, got: %+v", diffs)
+	}
+
+	kinds := []string{}
+	for _, c := range diffs[0].Changes {
+		kinds = append(kinds, c.Kind)
+	}
+	expected := []string{lineChanged, lineRemoved, lineAdded}
+	if len(kinds) != len(expected) {
+		t.Fatalf("expected: %v, got: %v", expected, kinds)
+	}
+	for i := range expected {
+		if kinds[i] != expected[i] {
+			t.Errorf("expected: %v, got: %v", expected, kinds)
+			break
+		}
+	}
+	if c := diffs[0].Changes[0]; c.Old.Line != 20 || c.New.Line != 24 {
+		t.Errorf("expected line 20 to have changed into 24, got: %d -> %d", c.Old.Line, c.New.Line)
+	}
+}
+
+func TestFileIdentity(t *testing.T) {
+	m := watchMatch{Repo: "old-org/billing", Path: "main.go"}
+	td := []struct {
+		left, right []watchMatch
+		expected    string
+	}{
+		{
+			[]watchMatch{m},
+			[]watchMatch{{Repo: "new-org/payments", Path: "main.go"}},
+			"main.go",
+		},
+		{
+			[]watchMatch{m, {Repo: "old-org/api"}},
+			[]watchMatch{{Repo: "new-org/billing"}, {Repo: "new-org/api"}},
+			"billing:main.go",
+		},
+		{
+			[]watchMatch{m},
+			[]watchMatch{m},
+			"old-org/billing:main.go",
+		},
+		{
+			[]watchMatch{m, {Repo: "other-org/api"}},
+			[]watchMatch{{Repo: "new-org/billing"}},
+			"old-org/billing:main.go",
+		},
+	}
+	for _, tc := range td {
+		if got := fileIdentity(tc.left, tc.right)(m); got != tc.expected {
+			t.Errorf("expected: %s, got: %s", tc.expected, got)
+		}
+	}
+}
+
+func TestDiffRuns(t *testing.T) {
+	old := savedRun{
+		name: "--from",
+		matches: []watchMatch{
+			{Repo: "a/x", Path: "main.go", Line: 1, Text: "OldClient()"},
+			{Repo: "a/y", Path: "gone.go", Line: 1, Text: "OldClient()"},
+			{Repo: "a/z", Path: "big.go", Line: 1, Text: "OldClient()"},
+		},
+		failed: map[string]bool{},
+	}
+	cur := savedRun{
+		name: "--to",
+		matches: []watchMatch{
+			{Repo: "a/x", Path: "main.go", Line: 1, Text: "OldClient()"},
+			{Repo: "a/x", Path: "main.go", Line: 2, Text: "OldClient()"},
+		},
+		// Too big to compare: everything on the old side would look removed
+		failed: map[string]bool{"a/z big.go": true},
+	}
+
+	files := func(diffs []fileDiff) []string {
+		got := []string{}
+		for _, d := range diffs {
+			got = append(got, d.File)
+		}
+		return got
+	}
+	if got := files(diffRuns(old, cur)); !reflect.DeepEqual(got, []string{"a/x:main.go", "a/y:gone.go"}) {
+		t.Errorf("expected the unreadable file to be left out, got: %v", got)
+	}
+
+	// --to didn't see everything, so a/y might not be gone at all
+	cur.capped = true
+	if got := files(diffRuns(old, cur)); !reflect.DeepEqual(got, []string{"a/x:main.go"}) {
+		t.Errorf("expected only files both sides saw, got: %v", got)
+	}
+}
+
+func TestSideQuery(t *testing.T) {
+	viper.Set("org", "coxley")
+	defer viper.Set("org", "")
+	flags.repo = "codesearch"
+	defer func() { flags.repo = "" }()
+
+	td := []struct {
+		scope    string
+		expected string
+	}{
+		{"org:old-org", "OldClient org:old-org"},
+		{"repo:old-org/billing", "OldClient repo:old-org/billing"},
+		{"language:go", "OldClient org:coxley repo:coxley/codesearch language:go"},
+	}
+	for _, tc := range td {
+		if got := sideQuery([]string{"OldClient"}, tc.scope); got != tc.expected {
+			t.Errorf("%q: expected: %q, got: %q", tc.scope, tc.expected, got)
+		}
+	}
+	if org := viper.GetString("org"); org != "coxley" {
+		t.Errorf("expected the configured org to be left alone, got: %q", org)
+	}
+}
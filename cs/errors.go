@@ -0,0 +1,46 @@
+// Errors and exit codes
+//
+// cs exits like grep: 0 when something matched, 1 when nothing did, and 2 when
+// something went wrong. Commands return errors rather than exiting themselves
+// so the status is decided in one place, and so they can run inside tests.
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// exitStatus ends a command with a status and nothing more to say
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+const (
+	// errNoMatches is grep's status 1: not a failure, but worth telling
+	// scripts about
+	errNoMatches = exitStatus(1)
+	// errSetupDone stops the command that triggered first-time setup
+	errSetupDone = exitStatus(0)
+)
+
+// matchStatus is grep's exit status for how many matches were found
+func matchStatus(n int) error {
+	if n == 0 {
+		return errNoMatches
+	}
+	return nil
+}
+
+// exitCode for an error returned by a command
+func exitCode(err error) int {
+	var status exitStatus
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &status):
+		return int(status)
+	}
+	return 2
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	td := []struct {
+		err      error
+		expected int
+	}{
+		{nil, 0},
+		{errNoMatches, 1},
+		{errSetupDone, 0},
+		{fmt.Errorf("wrapped: %w", errNoMatches), 1},
+		{errors.New("boom"), 2},
+		{&apiError{kind: failureAuth, status: 401}, 2},
+		{&rateLimitError{resource: "search"}, 2},
+	}
+	for _, tc := range td {
+		if got := exitCode(tc.err); got != tc.expected {
+			t.Errorf("%v: expected: %d, got: %d", tc.err, tc.expected, got)
+		}
+	}
+
+	if matchStatus(0) != errNoMatches || matchStatus(3) != nil {
+		t.Errorf("expected no matches to be status 1 and any to be 0")
+	}
+}
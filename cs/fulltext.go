@@ -0,0 +1,205 @@
+// Fetching full text at scale
+//
+// Big searches need thousands of files. They're fetched in chunks of GraphQL
+// aliases by a few workers at once, and the chunk size follows what GitHub
+// can handle: smaller after a timeout or a heavy response, bigger while
+// responses stay light.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// Bounds on how many files go in one GraphQL request
+const (
+	minChunkSize   = 5
+	startChunkSize = 50
+	maxChunkSize   = 100
+)
+
+// Responses past this are slow for GitHub to build and tend to time out
+const targetChunkBytes = 4 << 20
+
+// chunkSizer adapts how many files go in each request
+//
+// A size GitHub gave up on is never tried again, so it settles instead of
+// bouncing off the limit.
+type chunkSizer struct {
+	mu      sync.Mutex
+	size    int
+	ceiling int
+}
+
+func newChunkSizer() *chunkSizer {
+	return &chunkSizer{size: startChunkSize, ceiling: maxChunkSize}
+}
+
+func (c *chunkSizer) next() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// shrink after GitHub gave up on a chunk of some size
+func (c *chunkSizer) shrink(failed int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ceiling = min(c.ceiling, max(failed/2, minChunkSize))
+	c.size = min(c.size, c.ceiling)
+}
+
+// observe a response, aiming the next chunk at the target size
+func (c *chunkSizer) observe(files, bytes int) {
+	if files == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ideal := targetChunkBytes / max(bytes/files, 1)
+	if ideal < c.size {
+		c.size = max(ideal, minChunkSize)
+	} else {
+		c.size = min(min(c.size+c.size/2, ideal), c.ceiling)
+	}
+}
+
+// shrinkable failures might go away with a smaller chunk
+func shrinkable(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && (apiErr.kind == failureTooLarge || apiErr.kind == failureTransient)
+}
+
+// fetchConcurrently runs up to --concurrency full text requests at a time
+//
+// Chunks GitHub chokes on are split and requeued. Once they can't get any
+// smaller, their files are marked failed so the rest still come back. Any
+// other error stops everything.
+func fetchConcurrently(client *http.Client, result SearchResult, defaultBranches map[string]string) (FullText, error) {
+	queue := []FileKey{}
+	for key := range result {
+		queue = append(queue, key)
+	}
+	sort.Sort(FileKeys(queue))
+
+	fullText := FullText{Values: map[FileKey]string{}, Truncated: map[FileKey]bool{}, Binary: map[FileKey]bool{}, Failed: map[FileKey]error{}}
+	sizer := newChunkSizer()
+	prog := newProgress("fetching files", len(queue))
+	defer prog.finish()
+
+	var mu sync.Mutex
+	ready := sync.NewCond(&mu)
+	var inFlight int
+	var firstErr error
+
+	// take the next chunk, waiting while requeued files might still show up
+	take := func() []FileKey {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(queue) == 0 && inFlight > 0 && firstErr == nil {
+			ready.Wait()
+		}
+		if len(queue) == 0 || firstErr != nil {
+			return nil
+		}
+		n := min(sizer.next(), len(queue))
+		chunk := queue[:n:n]
+		queue = queue[n:]
+		inFlight++
+		return chunk
+	}
+
+	done := func(chunk []FileKey, part FullText, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		defer ready.Broadcast()
+		inFlight--
+
+		switch {
+		case err == nil:
+			for k, v := range part.Values {
+				fullText.Values[k] = v
+			}
+			for k, v := range part.Truncated {
+				fullText.Truncated[k] = v
+			}
+			for k, v := range part.Binary {
+				fullText.Binary[k] = v
+			}
+			for k, v := range part.Failed {
+				fullText.Failed[k] = v
+			}
+			prog.add(len(chunk))
+		case shrinkable(err) && len(chunk) > minChunkSize:
+			v("chunk of %d files failed (%v): splitting it", len(chunk), err)
+			sizer.shrink(len(chunk))
+			queue = append(chunk, queue...)
+		case shrinkable(err):
+			for _, key := range chunk {
+				fullText.Failed[key] = err
+			}
+			prog.add(len(chunk))
+		case firstErr == nil:
+			firstErr = err
+		}
+	}
+
+	workers := max(flags.concurrency, 1)
+	v("Fetching %d files with %d workers", len(queue), workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := take(); chunk != nil; chunk = take() {
+				part := SearchResult{}
+				for _, key := range chunk {
+					part[key] = result[key]
+				}
+				ft, err := getFullText(client, part, defaultBranches)
+				if err == nil {
+					var size int
+					for _, text := range ft.Values {
+						size += len(text)
+					}
+					sizer.observe(len(chunk), size)
+				}
+				done(chunk, ft, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return fullText, firstErr
+}
+
+// progress of a long fetch on stderr, only when someone's watching
+type progress struct {
+	what  string
+	total int
+	count int
+	tty   bool
+}
+
+func newProgress(what string, total int) *progress {
+	return &progress{what: what, total: total, tty: isatty.IsTerminal(os.Stderr.Fd())}
+}
+
+func (p *progress) add(n int) {
+	p.count += n
+	if p.tty {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", color.New(color.Faint).Sprintf("%s: %d/%d", p.what, p.count, p.total))
+	}
+}
+
+func (p *progress) finish() {
+	if p.tty && p.count > 0 {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+var fullTextAliasRe = regexp.MustCompile(`(t\d+):repository\(owner: "([^"]+)", name: "([^"]+)"\) \{\s*object\(expression:"[^:]+:([^"]+)"\)`)
+
+// fakeFullText answers full text queries with each file's path as its content,
+// timing out when asked for more than maxFiles at once
+func fakeFullText(t *testing.T, maxFiles int) (*httptest.Server, *[]int, *int) {
+	var mu sync.Mutex
+	sizes := []int{}
+	var running, peak int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		var req gqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("bad request: %v", err)
+			return
+		}
+		aliases := fullTextAliasRe.FindAllStringSubmatch(req.Query, -1)
+		mu.Lock()
+		sizes = append(sizes, len(aliases))
+		mu.Unlock()
+
+		if len(aliases) > maxFiles {
+			fmt.Fprint(rw, `{"data": null, "errors": [{"message": "Something went wrong while executing your query. This may be the result of a timeout."}]}`)
+			return
+		}
+		data := map[string]any{}
+		for _, a := range aliases {
+			data[a[1]] = map[string]any{"object": map[string]any{"text": a[4], "isTruncated": false}}
+		}
+		json.NewEncoder(rw).Encode(map[string]any{"data": data})
+	}))
+	return srv, &sizes, &peak
+}
+
+func TestFetchConcurrently(t *testing.T) {
+	srv, sizes, peak := fakeFullText(t, 20)
+	defer srv.Close()
+	prev := viper.Get("base_url")
+	viper.Set("base_url", srv.URL)
+	defer viper.Set("base_url", prev)
+	flags.concurrency = 3
+	defer func() { flags.concurrency = 0 }()
+
+	result := SearchResult{}
+	for i := 0; i < 237; i++ {
+		result[FileKey{Owner: "coxley", Name: "codesearch", Path: fmt.Sprintf("f%03d.go", i)}] = nil
+	}
+	branches := map[string]string{"coxley/codesearch": "main"}
+
+	fullText, err := fetchConcurrently(http.DefaultClient, result, branches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fullText.Values) != len(result) || len(fullText.Failed) != 0 {
+		t.Fatalf("expected all %d files, got: %d (%d failed)", len(result), len(fullText.Values), len(fullText.Failed))
+	}
+	for key := range result {
+		if fullText.Values[key] != key.Path {
+			t.Errorf("%s: got the wrong content: %q", key.Path, fullText.Values[key])
+		}
+	}
+
+	if (*sizes)[0] != startChunkSize {
+		t.Errorf("expected to start with %d files, got: %d", startChunkSize, (*sizes)[0])
+	}
+	var timeouts int
+	for _, n := range *sizes {
+		if n > 20 {
+			timeouts++
+		}
+	}
+	if timeouts == 0 || timeouts > flags.concurrency*2 {
+		t.Errorf("expected chunks to shrink after timing out, got sizes: %v", *sizes)
+	}
+	if *peak > flags.concurrency {
+		t.Errorf("expected at most %d requests at once, got: %d", flags.concurrency, *peak)
+	}
+}
+
+func TestFetchConcurrentlyGivesUp(t *testing.T) {
+	// Nothing is small enough
+	srv, _, _ := fakeFullText(t, 0)
+	defer srv.Close()
+	prev := viper.Get("base_url")
+	viper.Set("base_url", srv.URL)
+	defer viper.Set("base_url", prev)
+	flags.concurrency = 2
+	defer func() { flags.concurrency = 0 }()
+
+	result := SearchResult{}
+	for i := 0; i < 60; i++ {
+		result[FileKey{Owner: "coxley", Name: "codesearch", Path: fmt.Sprintf("f%03d.go", i)}] = nil
+	}
+	fullText, err := fetchConcurrently(http.DefaultClient, result, map[string]string{"coxley/codesearch": "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fullText.Failed) != len(result) {
+		t.Errorf("expected every file to be marked failed, got: %d", len(fullText.Failed))
+	}
+}
+
+func TestChunkSizer(t *testing.T) {
+	c := newChunkSizer()
+	c.observe(50, 50*1024)
+	if c.next() != 75 {
+		t.Errorf("expected light responses to grow the chunk, got: %d", c.next())
+	}
+	c.observe(75, 75*1024)
+	c.observe(100, 100*1024)
+	if c.next() != maxChunkSize {
+		t.Errorf("expected growth to stop at %d, got: %d", maxChunkSize, c.next())
+	}
+	c.observe(100, 100*(256<<10))
+	if c.next() != targetChunkBytes/(256<<10) {
+		t.Errorf("expected heavy responses to aim for the target, got: %d", c.next())
+	}
+
+	c = newChunkSizer()
+	c.shrink(50)
+	c.shrink(50)
+	if c.next() != 25 {
+		t.Errorf("expected failures of the same size to shrink once, got: %d", c.next())
+	}
+	c.observe(25, 25)
+	if c.next() != 25 {
+		t.Errorf("expected growth to stop below what failed, got: %d", c.next())
+	}
+	c.shrink(6)
+	if c.next() != minChunkSize {
+		t.Errorf("expected shrinking to stop at %d, got: %d", minChunkSize, c.next())
+	}
+}
@@ -0,0 +1,216 @@
+// Go packages as a unit
+//
+// Search works on files, but questions like "what does this package export"
+// need the whole directory. These helpers fetch a package's source from GitHub
+// and work out what it's imported as.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/mod/modfile"
+)
+
+// goPackage is a directory of Go source in a repo
+type goPackage struct {
+	owner  string
+	name   string
+	branch string
+	dir    string
+
+	importPath string
+	// Non-test .go files keyed by path
+	files map[string]string
+}
+
+func (p *goPackage) repoString() string {
+	return fmt.Sprintf("%s/%s", p.owner, p.name)
+}
+
+// goExport is a top-level, exported identifier
+//
+// Methods are left out. Without type information, searching for a method name
+// alone mostly finds unrelated code.
+type goExport struct {
+	name string
+	kind string
+}
+
+// parseRepoPath splits OWNER/REPO[/path] into its parts
+//
+// Like --repo, the owner can be left off a bare repo name when an org is
+// configured. With a path it's required: "codesearch/cs" is the "cs" repo of
+// "codesearch", never a directory of [org]/codesearch.
+func parseRepoPath(s string) (owner, name, dir string, err error) {
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	if org := viper.GetString("org"); org != "" && len(parts) == 1 {
+		parts = append([]string{org}, parts...)
+	}
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("expected owner/repo[/path], got: %s", s)
+	}
+	return parts[0], parts[1], path.Join(parts[2:]...), nil
+}
+
+// repoForImportPath finds the repo and directory behind an import path
+// hosted on the GitHub instance we talk to
+//
+// Vanity import paths can't be mapped and return an error.
+func repoForImportPath(importPath string) (owner, name, dir string, err error) {
+	host := "github.com"
+	if u, err := url.Parse(makeGithubSiteURL("")); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	parts := strings.Split(importPath, "/")
+	if len(parts) < 3 || parts[0] != host {
+		return "", "", "", fmt.Errorf("%s isn't hosted on %s", importPath, host)
+	}
+
+	// Major version suffixes only exist in the import path, not the tree.
+	// This is wrong for repos using major version subdirectories but those
+	// are rare.
+	rest := parts[3:]
+	if len(rest) > 0 && isMajorVersion(rest[0]) {
+		rest = rest[1:]
+	}
+	return parts[1], parts[2], path.Join(rest...), nil
+}
+
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// loadGoPackage fetches the source of a package and the go.mod governing it
+func loadGoPackage(client *http.Client, owner, name, dir string) (*goPackage, error) {
+	repoKey := FileKey{Owner: owner, Name: name}
+	branches, err := getDefaultBranches(client, SearchResult{repoKey: nil})
+	if err != nil {
+		return nil, err
+	}
+	branch := branches[repoKey.RepoString()]
+	if branch == "" {
+		return nil, fmt.Errorf("couldn't find repo: %s", repoKey.RepoString())
+	}
+
+	paths, err := getTreeFiles(client, owner, name, branch, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	toFetch := SearchResult{}
+	for _, p := range paths {
+		if strings.HasSuffix(p, ".go") && !strings.HasSuffix(p, "_test.go") {
+			toFetch[FileKey{owner, name, p}] = nil
+		}
+	}
+	if len(toFetch) == 0 {
+		return nil, fmt.Errorf("no Go files in %s/%s", repoKey.RepoString(), dir)
+	}
+
+	// Any directory from here to the root could hold the go.mod
+	modDirs := []string{}
+	for d := dir; ; d = path.Dir(d) {
+		if d == "." || d == "/" {
+			d = ""
+		}
+		modDirs = append(modDirs, d)
+		toFetch[FileKey{owner, name, path.Join(d, "go.mod")}] = nil
+		if d == "" {
+			break
+		}
+	}
+
+	fullText, err := fetchFullText(client, toFetch, branches)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &goPackage{
+		owner:  owner,
+		name:   name,
+		branch: branch,
+		dir:    dir,
+		files:  map[string]string{},
+	}
+	for key, content := range fullText.Values {
+		if strings.HasSuffix(key.Path, ".go") {
+			pkg.files[key.Path] = content
+		}
+	}
+
+	// Without a go.mod, assume the old GOPATH layout
+	pkg.importPath = path.Join("github.com", owner, name, dir)
+	for _, d := range modDirs {
+		gomod := fullText.Values[FileKey{owner, name, path.Join(d, "go.mod")}]
+		if mod := modfile.ModulePath([]byte(gomod)); mod != "" {
+			rel := strings.TrimPrefix(strings.TrimPrefix(dir, d), "/")
+			pkg.importPath = path.Join(mod, rel)
+			break
+		}
+	}
+	return pkg, nil
+}
+
+// exports of the package, sorted by name
+func (p *goPackage) exports() []goExport {
+	seen := map[string]struct{}{}
+	exports := []goExport{}
+	add := func(name, kind string) {
+		if _, ok := seen[name]; ok || !gotoken.IsExported(name) {
+			return
+		}
+		seen[name] = struct{}{}
+		exports = append(exports, goExport{name, kind})
+	}
+
+	for filename, content := range p.files {
+		fset := gotoken.NewFileSet()
+		f, err := parser.ParseFile(fset, filename, content, parser.SkipObjectResolution)
+		if err != nil {
+			v("couldn't parse %s: %v", filename, err)
+			continue
+		}
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					add(d.Name.Name, "func")
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						add(s.Name.Name, "type")
+					case *ast.ValueSpec:
+						for _, ident := range s.Names {
+							add(ident.Name, d.Tok.String())
+						}
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].name < exports[j].name
+	})
+	return exports
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseRepoPath(t *testing.T) {
+	viper.Set("org", "coxley")
+	defer viper.Set("org", "")
+
+	td := map[string][3]string{
+		"codesearch":               {"coxley", "codesearch", ""},
+		"codesearch/cs":            {"codesearch", "cs", ""},
+		"coxley/codesearch/cs":     {"coxley", "codesearch", "cs"},
+		"coxley/codesearch/cs/sub": {"coxley", "codesearch", "cs/sub"},
+	}
+	for arg, expected := range td {
+		owner, name, dir, err := parseRepoPath(arg)
+		if err != nil || [3]string{owner, name, dir} != expected {
+			t.Errorf("%s: expected: %v, got: %v %v", arg, expected, [3]string{owner, name, dir}, err)
+		}
+	}
+}
+
+func TestRepoForImportPath(t *testing.T) {
+	viper.Set("base_url", "https://api.github.com/")
+
+	td := map[string][3]string{
+		"github.com/coxley/codesearch/cs":        {"coxley", "codesearch", "cs"},
+		"github.com/google/go-github/v47/github": {"google", "go-github", "github"},
+		"github.com/spf13/viper":                 {"spf13", "viper", ""},
+	}
+	for importPath, expected := range td {
+		owner, name, dir, err := repoForImportPath(importPath)
+		if err != nil || [3]string{owner, name, dir} != expected {
+			t.Errorf("%s: expected: %v, got: %v %v", importPath, expected, [3]string{owner, name, dir}, err)
+		}
+	}
+
+	if _, _, _, err := repoForImportPath("golang.org/x/mod/modfile"); err == nil {
+		t.Errorf("expected an error for a path not hosted on GitHub")
+	}
+}
+
+func TestGoPackageExports(t *testing.T) {
+	pkg := &goPackage{files: map[string]string{
+		"a.go": "package a\n\nfunc Exported() {}\nfunc unexported() {}\n\ntype (\n\tT struct{}\n\tu int\n)\n\nfunc (T) Method() {}\n",
+		"b.go": "package a\n\nconst A, b = 1, 2\n\nvar V = 1\n",
+	}}
+
+	expected := []goExport{{"A", "const"}, {"Exported", "func"}, {"T", "type"}, {"V", "var"}}
+	got := pkg.exports()
+	if len(got) != len(expected) {
+		t.Fatalf("expected: %v, got: %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected: %v, got: %v", expected, got)
+			break
+		}
+	}
+}
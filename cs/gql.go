@@ -11,10 +11,10 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"path"
 	"strings"
 	"text/template"
 	"time"
@@ -56,7 +56,7 @@ query {
 //   - Search API supporting only searching the default branch
 //   - The GraphQL API for file content requiring branch
 //   - Needing the full file content because the Search API returns partial lines
-func getDefaultBranches(client *http.Client, result SearchResult) map[string]string {
+func getDefaultBranches(client *http.Client, result SearchResult) (map[string]string, error) {
 	// Create map with all equal values to avoid complexity in downstream functions
 	if name := viper.GetString("defaultBranch"); name != "" {
 		v("Using configured default branch for everything: %s", name)
@@ -64,7 +64,7 @@ func getDefaultBranches(client *http.Client, result SearchResult) map[string]str
 		for key := range result {
 			defaultBranches[fmt.Sprintf("%s/%s", key.Owner, key.Name)] = name
 		}
-		return defaultBranches
+		return defaultBranches, nil
 	}
 
 	start := time.Now()
@@ -82,12 +82,14 @@ func getDefaultBranches(client *http.Client, result SearchResult) map[string]str
 
 	var i int
 	seen := map[string]struct{}{}
+	queryAliases := map[string]string{}
 	for key := range result {
 		seenKey := fmt.Sprint(key.Owner, key.Name)
 		if _, ok := seen[seenKey]; ok {
 			continue
 		}
 		data = append(data, tmplData{i, key.Owner, key.Name})
+		queryAliases[fmt.Sprintf("b%d", i)] = key.RepoString()
 		seen[seenKey] = struct{}{}
 		i++
 	}
@@ -96,48 +98,37 @@ func getDefaultBranches(client *http.Client, result SearchResult) map[string]str
 	t := template.Must(template.New("branches").Parse(defaultBranchesTempl))
 	err := t.Execute(&query, data)
 	if err != nil {
-		fatalf("failed to query branch data: %v", err)
+		return nil, fmt.Errorf("failed to query branch data: %w", err)
 	}
 
-	gql, err := json.Marshal(gqlRequest{Query: query.String()})
-	if err != nil {
-		fatalf("failed to create gql request as json: %v", err)
-	}
-
-	resp, err := client.Post(gqlURL(), "application/json", bytes.NewReader(gql))
-	if err != nil {
-		fatalf("gql request to fetch branches failed: %v", err)
-	}
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fatalf("failed to read response body from gql: %v", err)
-	}
-
-	type gqlResponse struct {
-		Data map[string]struct {
-			Owner struct {
-				Login string
-			}
-			Name             string
-			DefaultBranchRef struct {
-				Name string
-			}
+	// Repos we can't see come back as null
+	var gr map[string]*struct {
+		DefaultBranchRef *struct {
+			Name string
 		}
 	}
-
-	var gr gqlResponse
-	err = json.Unmarshal(b, &gr)
+	aliasErrs, err := postGraphQL(client, query.String(), &gr)
 	if err != nil {
-		fatalf("gql response failed to unmarshal: %v", err)
+		return nil, fmt.Errorf("couldn't get default branches: %w", err)
 	}
 
 	defaultBranches := map[string]string{}
-	for _, repo := range gr.Data {
-		fullName := fmt.Sprintf("%s/%s", repo.Owner.Login, repo.Name)
-		defaultBranches[fullName] = repo.DefaultBranchRef.Name
+	failed := map[string]error{}
+	for alias, fullName := range queryAliases {
+		repo := gr[alias]
+		switch {
+		case aliasErrs[alias] != nil:
+			failed[fullName] = aliasErrs[alias]
+		case repo == nil:
+			failed[fullName] = &apiError{kind: failureNotFound}
+		case repo.DefaultBranchRef == nil:
+			failed[fullName] = &apiError{kind: failureNotFound, message: "repo is empty"}
+		default:
+			defaultBranches[fullName] = repo.DefaultBranchRef.Name
+		}
 	}
-	return defaultBranches
+	reportFailures("repos", len(queryAliases), failed)
+	return defaultBranches, nil
 }
 
 var fullTextTempl = `
@@ -146,6 +137,9 @@ query {
 	{{printf "t%d" .Idx}}:repository(owner: "{{.Owner}}", name: "{{.Name}}") {
 		object(expression:"{{.Branch}}:{{.Path}}") {
 			... on Blob {
+				oid
+				byteSize
+				isBinary
 				text
 				isTruncated
 			}
@@ -158,47 +152,65 @@ query {
 type FullText struct {
 	Values map[FileKey]string
 	// Github MAY truncate the contents of a file. Luckily it can tell us when
-	// it happens.
+	// it happens. Only files that couldn't be fetched in full any other way
+	// stay truncated. (see blob.go)
 	Truncated map[FileKey]bool
+	Binary    map[FileKey]bool
+	// Files that couldn't be fetched, and why
+	Failed map[FileKey]error
 }
 
-func paginateFullText(client *http.Client, result SearchResult, defaultBranches map[string]string) FullText {
-	chunks := []SearchResult{}
-	chunkSz := 100
+var (
+	// Its repo failed or is empty, which is reported on its own
+	errNoBranch = errors.New("default branch unknown")
+	// Search indexes lag behind pushes
+	errNotOnBranch = errors.New("file isn't on the default branch any more")
+)
 
-	var i int
-	cur := SearchResult{}
-	for k, v := range result {
-		if i == chunkSz {
-			chunks = append(chunks, cur)
-			cur = SearchResult{}
-			i = 0
+// fetchFullText of every file, concurrently in chunks when there are many
+//
+// Files that couldn't be fetched are reported and left out of Values.
+func fetchFullText(client *http.Client, result SearchResult, defaultBranches map[string]string) (FullText, error) {
+	known := SearchResult{}
+	noBranch := []FileKey{}
+	for key, tm := range result {
+		if _, ok := defaultBranches[key.RepoString()]; ok {
+			known[key] = tm
+		} else {
+			noBranch = append(noBranch, key)
 		}
-		cur[k] = v
-		i++
 	}
 
-	v("GQL pages to run: %d", len(chunks))
-
-	res := FullText{Values: map[FileKey]string{}, Truncated: map[FileKey]bool{}}
-	for page, chunk := range chunks {
-		v("GQL Page: %d", page)
+	fullText := FullText{Values: map[FileKey]string{}, Truncated: map[FileKey]bool{}, Binary: map[FileKey]bool{}, Failed: map[FileKey]error{}}
+	var err error
+	switch {
+	case len(known) > startChunkSize:
+		fullText, err = fetchConcurrently(client, known, defaultBranches)
+	case len(known) > 0:
+		fullText, err = getFullText(client, known, defaultBranches)
+	}
+	if err != nil {
+		return fullText, err
+	}
 
-		pr := getFullText(client, chunk, defaultBranches)
-		for k, v := range pr.Values {
-			res.Values[k] = v
+	// Missing files are expected by some callers, so they're left to them
+	failed := map[string]error{}
+	for key, err := range fullText.Failed {
+		if !errors.Is(err, errNotOnBranch) {
+			failed[key.String()] = err
 		}
+	}
+	reportFailures("files", len(known), failed)
 
-		for k, v := range pr.Truncated {
-			res.Truncated[k] = v
-		}
+	for _, key := range noBranch {
+		fullText.Failed[key] = errNoBranch
 	}
-	return res
+	return fullText, nil
 }
 
 // getFullText is a workaround of...
 //   - The Search API returning partial lines surrounding the matching terms
-func getFullText(client *http.Client, result SearchResult, defaultBranches map[string]string) FullText {
+func getFullText(client *http.Client, result SearchResult, defaultBranches map[string]string) (FullText, error) {
 	start := time.Now()
 	defer func() {
 		v("Getting full text of files took %s", time.Since(start))
@@ -236,50 +248,114 @@ func getFullText(client *http.Client, result SearchResult, defaultBranches map[s
 	t := template.Must(template.New("fulltext").Parse(fullTextTempl))
 	err := t.Execute(&query, data)
 	if err != nil {
-		fatalf("failed to query file data: %v", err)
+		return FullText{}, fmt.Errorf("failed to query file data: %w", err)
 	}
 
 	qstr := query.String()
 	v("full text gql: %s", qstr)
 
-	gql, err := json.Marshal(gqlRequest{Query: qstr})
+	// Paths that don't exist on the branch come back as a null object
+	var gr map[string]*struct {
+		Object *struct {
+			Oid         string
+			ByteSize    int64
+			IsBinary    *bool
+			Text        string
+			IsTruncated bool
+		}
+	}
+	aliasErrs, err := postGraphQL(client, qstr, &gr)
 	if err != nil {
-		fatalf("failed to create gql request as json: %v", err)
+		return FullText{}, fmt.Errorf("couldn't get file contents: %w", err)
 	}
 
-	resp, err := client.Post(gqlURL(), "application/json", bytes.NewReader(gql))
-	if err != nil {
-		fatalf("gql request to fetch file contents failed: %v", err)
+	fullText := FullText{Values: map[FileKey]string{}, Truncated: map[FileKey]bool{}, Binary: map[FileKey]bool{}, Failed: map[FileKey]error{}}
+	blobs := map[FileKey]blobInfo{}
+	for alias, key := range queryAliases {
+		v("gql alias to filename: %s => %s", alias, key.String())
+		repo := gr[alias]
+		switch {
+		case aliasErrs[alias] != nil:
+			fullText.Failed[key] = aliasErrs[alias]
+		case repo == nil:
+			fullText.Failed[key] = &apiError{kind: failureNotFound, message: key.RepoString()}
+		case repo.Object == nil:
+			fullText.Failed[key] = errNotOnBranch
+		default:
+			fullText.Values[key] = repo.Object.Text
+			if repo.Object.IsTruncated {
+				fullText.Truncated[key] = true
+			}
+			blobs[key] = blobInfo{
+				oid:      repo.Object.Oid,
+				byteSize: repo.Object.ByteSize,
+				binary:   repo.Object.IsBinary != nil && *repo.Object.IsBinary,
+			}
+		}
+	}
+	completeTruncated(client, fullText, blobs)
+	return fullText, nil
+}
+
+var treeTempl = `
+query {
+	repository(owner: "{{.Owner}}", name: "{{.Name}}") {
+		object(expression:"{{.Branch}}:{{.Dir}}") {
+			... on Tree {
+				entries {
+					name
+					type
+				}
+			}
+		}
 	}
+}
+`
 
-	b, err := ioutil.ReadAll(resp.Body)
+// getTreeFiles lists paths of the files directly inside a directory
+//
+// Search only tells us about files that matched. Commands that need to look at
+// a whole package (like 'unused') use this to know what to fetch.
+func getTreeFiles(client *http.Client, owner, name, branch, dir string) ([]string, error) {
+	var query bytes.Buffer
+	t := template.Must(template.New("tree").Parse(treeTempl))
+	err := t.Execute(&query, map[string]string{
+		"Owner":  owner,
+		"Name":   name,
+		"Branch": branch,
+		"Dir":    dir,
+	})
 	if err != nil {
-		fatalf("failed to read response body from gql: %v", err)
+		return nil, fmt.Errorf("failed to query tree data: %w", err)
 	}
 
-	type gqlResponse struct {
-		Data map[string]struct {
-			Object struct {
-				Text        string
-				IsTruncated bool
+	var gr struct {
+		Repository *struct {
+			Object *struct {
+				Entries []struct {
+					Name string
+					Type string
+				}
 			}
 		}
 	}
-
-	var gr gqlResponse
-	err = json.Unmarshal(b, &gr)
+	aliasErrs, err := postGraphQL(client, query.String(), &gr)
 	if err != nil {
-		fatalf("gql response failed to unmarshal: %v", err)
+		return nil, fmt.Errorf("couldn't list %s/%s/%s: %w", owner, name, dir, err)
+	}
+	if err := aliasErrs["repository"]; err != nil {
+		return nil, fmt.Errorf("couldn't list %s/%s/%s: %w", owner, name, dir, err)
+	}
+	if gr.Repository == nil || gr.Repository.Object == nil {
+		return nil, fmt.Errorf("couldn't list %s/%s/%s: %w", owner, name, dir, &apiError{kind: failureNotFound})
 	}
 
-	fullText := FullText{Values: map[FileKey]string{}, Truncated: map[FileKey]bool{}}
-	for alias, repo := range gr.Data {
-		key := queryAliases[alias]
-		v("gql alias to filename: %s => %s", alias, key.String())
-		fullText.Values[key] = repo.Object.Text
-		if repo.Object.IsTruncated {
-			fullText.Truncated[key] = true
+	paths := []string{}
+	for _, entry := range gr.Repository.Object.Entries {
+		if entry.Type != "blob" {
+			continue
 		}
+		paths = append(paths, path.Join(dir, entry.Name))
 	}
-	return fullText
+	return paths, nil
 }
@@ -0,0 +1,406 @@
+// Talking to GitHub
+//
+// GitHub fails in a handful of ways that each deserve different handling: a
+// bad token won't fix itself, a 502 usually does, and a GraphQL query for fifty
+// files can come back with forty-nine of them. Everything that talks to the API
+// goes through here so failures are named instead of showing up as empty
+// content further down the line.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+)
+
+type failureKind int
+
+const (
+	failureOther failureKind = iota
+	failureAuth
+	failureNotFound
+	failureRateLimited
+	failureTransient
+	failureNetwork
+	failureQuery
+	failureTooLarge
+)
+
+func (k failureKind) String() string {
+	switch k {
+	case failureAuth:
+		return "permission denied"
+	case failureNotFound:
+		return "not found"
+	case failureRateLimited:
+		return "rate limited"
+	case failureTransient:
+		return "GitHub is having trouble"
+	case failureNetwork:
+		return "couldn't reach GitHub"
+	case failureQuery:
+		return "invalid query"
+	case failureTooLarge:
+		return "query too large"
+	}
+	return "request failed"
+}
+
+// apiError is a failed request, or part of one for GraphQL
+//
+// Anything talking to GitHub returns one of these, so callers can tell a bad
+// token from a bad query from a flaky network with errors.As.
+type apiError struct {
+	kind    failureKind
+	status  int
+	message string
+	// What went wrong underneath, for network failures
+	err error
+}
+
+func (e *apiError) Error() string {
+	msg := e.kind.String()
+	if e.status != 0 {
+		msg += fmt.Sprintf(" (%d)", e.status)
+	}
+	if e.message != "" {
+		msg += ": " + e.message
+	} else if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	if e.kind == failureAuth && e.status == http.StatusUnauthorized {
+		msg += ": check your token with 'cs set-token'"
+	}
+	return msg
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// githubError classifies errors from go-github the same way as our own
+// requests
+func githubError(err error) error {
+	var rle *github.RateLimitError
+	var abuse *github.AbuseRateLimitError
+	var resp *github.ErrorResponse
+	switch {
+	case errors.As(err, &rle):
+		return &rateLimitError{resource: "search", reset: rle.Rate.Reset.Time}
+	case errors.As(err, &abuse):
+		return &apiError{kind: failureRateLimited, status: abuse.Response.StatusCode, message: abuse.Message}
+	case errors.As(err, &resp):
+		e := &apiError{kind: failureOther, status: resp.Response.StatusCode, message: resp.Message}
+		for _, detail := range resp.Errors {
+			if detail.Message != "" {
+				e.message += ": " + detail.Message
+			}
+		}
+		switch resp.Response.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			e.kind = failureAuth
+		case http.StatusNotFound:
+			e.kind = failureNotFound
+		case http.StatusUnprocessableEntity:
+			e.kind = failureQuery
+		default:
+			if transientStatus(resp.Response.StatusCode) {
+				e.kind = failureTransient
+			}
+		}
+		return e
+	}
+	return err
+}
+
+// How many times a request is retried when GitHub or the network hiccups
+const maxRetries = 4
+
+// Backoff doubles from the first delay up to the last, with jitter so
+// concurrent requests don't retry in lockstep
+const (
+	firstBackoff = 500 * time.Millisecond
+	maxBackoff   = 10 * time.Second
+)
+
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// backoff before a retry: somewhere between half and all of the doubled delay
+func backoff(attempt int) time.Duration {
+	d := firstBackoff << attempt
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	jitter.Lock()
+	defer jitter.Unlock()
+	return d/2 + time.Duration(jitter.Int63n(int64(d/2)+1))
+}
+
+// classifyStatus of a response, or nil if it succeeded
+//
+// REST errors carry a message in the body which is worth showing. The body is
+// consumed either way.
+func classifyStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Message string `json:"message"`
+	}
+	message := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &body) == nil && body.Message != "" {
+		message = body.Message
+	}
+	if len(message) > 200 {
+		message = message[:200] + "..."
+	}
+
+	e := &apiError{kind: failureOther, status: resp.StatusCode, message: message}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		e.kind = failureAuth
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.kind = failureRateLimited
+	case resp.StatusCode == http.StatusForbidden:
+		e.kind = failureAuth
+		if resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			e.kind = failureRateLimited
+		}
+	case resp.StatusCode == http.StatusNotFound:
+		e.kind = failureNotFound
+	case transientStatus(resp.StatusCode):
+		e.kind = failureTransient
+	}
+	return e
+}
+
+func transientStatus(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// transientErr is a network failure that's worth trying again
+func transientErr(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// replayRequest for another attempt, since each one reads the body
+func replayRequest(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("can't retry %s %s: request body can't be replayed", req.Method, req.URL)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	send := req.Clone(req.Context())
+	send.Body = body
+	return send, nil
+}
+
+// retryTransport retries server errors and network hiccups with backoff
+//
+// Rate limits are left to rateLimitTransport underneath. Everything else is
+// for the caller to deal with.
+type retryTransport struct {
+	base http.RoundTripper
+	// Swapped out by tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sleep := t.sleep
+	if sleep == nil {
+		sleep = sleepCtx
+	}
+	for attempt := 0; ; attempt++ {
+		send, err := replayRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(send)
+		retry := attempt < maxRetries
+		switch {
+		case err != nil && (!retry || !transientErr(err)):
+			return nil, networkError(err)
+		case err == nil && (!retry || !transientStatus(resp.StatusCode)):
+			return resp, nil
+		case err == nil:
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			err = fmt.Errorf("%s", resp.Status)
+		}
+
+		d := backoff(attempt)
+		v("%s %s failed (%v): retrying in %s", req.Method, req.URL.Path, err, d.Round(time.Millisecond))
+		if err := sleep(req.Context(), d); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// networkError marks failures to talk to GitHub at all
+//
+// Cancellation and rate limits already say what happened.
+func networkError(err error) error {
+	var rle *rateLimitError
+	var apiErr *apiError
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &rle) || errors.As(err, &apiErr) {
+		return err
+	}
+	return &apiError{kind: failureNetwork, err: err}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type gqlError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Path    []any  `json:"path"`
+}
+
+func (e gqlError) apiError() *apiError {
+	kind := failureOther
+	switch e.Type {
+	case "NOT_FOUND":
+		kind = failureNotFound
+	case "FORBIDDEN", "INSUFFICIENT_SCOPES":
+		kind = failureAuth
+	case "RATE_LIMITED":
+		kind = failureRateLimited
+	case "SERVICE_UNAVAILABLE", "TIMEOUT":
+		kind = failureTransient
+	case "MAX_NODE_LIMIT_EXCEEDED", "RESOURCE_LIMITS_EXCEEDED":
+		kind = failureTooLarge
+	case "":
+		// Timeouts don't come with a type, just an apology
+		if strings.Contains(strings.ToLower(e.Message), "timeout") {
+			kind = failureTransient
+		}
+	}
+	return &apiError{kind: kind, message: e.Message}
+}
+
+// postGraphQL runs a query and decodes its data into out
+//
+// Errors for individual aliases come back keyed by alias, so one missing repo
+// doesn't throw away the rest. The error is for the query as a whole.
+func postGraphQL(client *http.Client, query string, out any) (map[string]error, error) {
+	gql, err := json.Marshal(gqlRequest{Query: query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gql request as json: %w", err)
+	}
+
+	resp, err := client.Post(gqlURL(), "application/json", bytes.NewReader(gql))
+	if err != nil {
+		return nil, fmt.Errorf("gql request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := classifyStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var gr struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []gqlError      `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&gr); err != nil {
+		return nil, fmt.Errorf("gql response failed to unmarshal: %w", err)
+	}
+
+	// No data means the query itself was rejected
+	if len(gr.Data) == 0 || string(gr.Data) == "null" {
+		if len(gr.Errors) == 0 {
+			return nil, &apiError{kind: failureOther, message: "gql response had no data"}
+		}
+		e := gr.Errors[0].apiError()
+		for _, other := range gr.Errors[1:] {
+			e.message += "; " + other.Message
+		}
+		return nil, e
+	}
+	if err := json.Unmarshal(gr.Data, out); err != nil {
+		return nil, fmt.Errorf("gql response failed to unmarshal: %w", err)
+	}
+
+	aliasErrs := map[string]error{}
+	for _, e := range gr.Errors {
+		if len(e.Path) == 0 {
+			w("GitHub reported a problem with the query: %s", e.Message)
+			continue
+		}
+		alias := fmt.Sprint(e.Path[0])
+		if _, ok := aliasErrs[alias]; !ok {
+			aliasErrs[alias] = e.apiError()
+		}
+	}
+	return aliasErrs, nil
+}
+
+// How many failures reportFailures lists before summarizing the rest
+const maxReportedFailures = 10
+
+// reportFailures of part of a request, so it's clear the results are
+// incomplete and why
+func reportFailures(what string, total int, failed map[string]error) {
+	if len(failed) == 0 {
+		return
+	}
+	names := []string{}
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w("couldn't fetch %d of %d %s: results are incomplete", len(failed), total, what)
+	for i, name := range names {
+		if i == maxReportedFailures && !flags.verbose {
+			w("  ...and %d more (-v to see all)", len(names)-i)
+			break
+		}
+		w("  %s: %v", name, failed[name])
+	}
+}
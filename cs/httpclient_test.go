@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"github.com/spf13/viper"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	td := []struct {
+		status  int
+		headers map[string]string
+		body    string
+		kind    failureKind
+		message string
+	}{
+		{401, nil, `{"message": "Bad credentials"}`, failureAuth, "Bad credentials"},
+		{403, nil, `{"message": "Resource not accessible by integration"}`, failureAuth, "Resource not accessible by integration"},
+		{403, map[string]string{"X-RateLimit-Remaining": "0"}, "", failureRateLimited, ""},
+		{429, nil, "", failureRateLimited, ""},
+		{404, nil, `{"message": "Not Found"}`, failureNotFound, "Not Found"},
+		{502, nil, "<html>Bad Gateway</html>", failureTransient, "<html>Bad Gateway</html>"},
+		{422, nil, `{"message": "Validation Failed"}`, failureOther, "Validation Failed"},
+	}
+	for _, tc := range td {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(tc.body))}
+		for k, v := range tc.headers {
+			resp.Header.Set(k, v)
+		}
+		var apiErr *apiError
+		if err := classifyStatus(resp); !errors.As(err, &apiErr) {
+			t.Errorf("%d: expected an apiError, got: %v", tc.status, err)
+			continue
+		}
+		if apiErr.kind != tc.kind || apiErr.message != tc.message {
+			t.Errorf("%d: expected: %v %q, got: %v %q", tc.status, tc.kind, tc.message, apiErr.kind, apiErr.message)
+		}
+	}
+
+	ok := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(""))}
+	if err := classifyStatus(ok); err != nil {
+		t.Errorf("expected no error for 200, got: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := firstBackoff << attempt
+		if d > maxBackoff {
+			d = maxBackoff
+		}
+		for i := 0; i < 20; i++ {
+			if got := backoff(attempt); got < d/2 || got > d {
+				t.Fatalf("attempt %d: expected between %s and %s, got: %s", attempt, d/2, d, got)
+			}
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	statuses := []int{502, 503, 200}
+	var calls int
+	bodies := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		status := statuses[min(calls, len(statuses)-1)]
+		calls++
+		rw.WriteHeader(status)
+		fmt.Fprint(rw, status)
+	}))
+	defer srv.Close()
+
+	var slept []time.Duration
+	client := &http.Client{Transport: &retryTransport{
+		base: http.DefaultTransport,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		},
+	}}
+
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || calls != 3 || len(slept) != 2 {
+		t.Errorf("expected success after two retries, got: %d after %d calls, slept %v", resp.StatusCode, calls, slept)
+	}
+	if strings.Join(bodies, "") != "{}{}{}" {
+		t.Errorf("expected the body to be replayed, got: %q", bodies)
+	}
+
+	// Permanent failures are returned straight away
+	statuses, calls, slept = []int{404}, 0, nil
+	resp, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 || calls != 1 || len(slept) != 0 {
+		t.Errorf("expected 404 without retries, got: %d after %d calls", resp.StatusCode, calls)
+	}
+
+	// Giving up eventually hands back the last response
+	statuses, calls, slept = []int{503}, 0, nil
+	resp, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 || calls != maxRetries+1 {
+		t.Errorf("expected to give up after %d calls, got: %d after %d calls", maxRetries+1, resp.StatusCode, calls)
+	}
+}
+
+func TestPostGraphQL(t *testing.T) {
+	var reply string
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(status)
+		fmt.Fprint(rw, reply)
+	}))
+	defer srv.Close()
+
+	prev := viper.Get("base_url")
+	viper.Set("base_url", srv.URL)
+	defer viper.Set("base_url", prev)
+
+	type repo struct {
+		Name string
+	}
+
+	// One alias failing keeps the rest
+	status = 200
+	reply = `{
+		"data": {"b0": {"name": "codesearch"}, "b1": null},
+		"errors": [{"type": "NOT_FOUND", "path": ["b1"], "message": "Could not resolve to a Repository with the name 'coxley/gone'."}]
+	}`
+	var data map[string]*repo
+	aliasErrs, err := postGraphQL(http.DefaultClient, "query {}", &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["b0"] == nil || data["b0"].Name != "codesearch" {
+		t.Errorf("expected b0 to be decoded, got: %v", data)
+	}
+	var apiErr *apiError
+	if !errors.As(aliasErrs["b1"], &apiErr) || apiErr.kind != failureNotFound {
+		t.Errorf("expected b1 to be not found, got: %v", aliasErrs)
+	}
+	if len(aliasErrs) != 1 {
+		t.Errorf("expected only b1 to fail, got: %v", aliasErrs)
+	}
+
+	// No data means the whole query failed
+	reply = `{"data": null, "errors": [{"type": "FORBIDDEN", "message": "nope"}]}`
+	if _, err := postGraphQL(http.DefaultClient, "query {}", &data); !errors.As(err, &apiErr) || apiErr.kind != failureAuth {
+		t.Errorf("expected a permission error, got: %v", err)
+	}
+
+	// HTTP errors are classified before looking at the body
+	status, reply = 401, `{"message": "Bad credentials"}`
+	if _, err := postGraphQL(http.DefaultClient, "query {}", &data); !errors.As(err, &apiErr) || apiErr.kind != failureAuth {
+		t.Errorf("expected an auth error, got: %v", err)
+	}
+}
+
+func TestGithubError(t *testing.T) {
+	resp := func(status int) *http.Response {
+		return &http.Response{StatusCode: status, Request: &http.Request{Method: "GET", URL: &url.URL{}}}
+	}
+	td := []struct {
+		err  error
+		kind failureKind
+	}{
+		{&github.ErrorResponse{Response: resp(401), Message: "Bad credentials"}, failureAuth},
+		{&github.ErrorResponse{Response: resp(404), Message: "Not Found"}, failureNotFound},
+		{&github.ErrorResponse{Response: resp(422), Message: "Validation Failed"}, failureQuery},
+		{&github.ErrorResponse{Response: resp(502)}, failureTransient},
+		{&github.AbuseRateLimitError{Response: resp(403)}, failureRateLimited},
+	}
+	for _, tc := range td {
+		var apiErr *apiError
+		if err := githubError(tc.err); !errors.As(err, &apiErr) || apiErr.kind != tc.kind {
+			t.Errorf("%v: expected kind %v, got: %v", tc.err, tc.kind, err)
+		}
+	}
+
+	var rle *rateLimitError
+	if err := githubError(&github.RateLimitError{Response: resp(403)}); !errors.As(err, &rle) {
+		t.Errorf("expected a rateLimitError, got: %v", err)
+	}
+	if err := githubError(context.Canceled); err != context.Canceled {
+		t.Errorf("expected other errors to pass through, got: %v", err)
+	}
+}
+
+func TestNetworkError(t *testing.T) {
+	var apiErr *apiError
+	err := networkError(syscall.ECONNREFUSED)
+	if !errors.As(err, &apiErr) || apiErr.kind != failureNetwork || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected a network error wrapping the cause, got: %v", err)
+	}
+	if err := networkError(context.Canceled); err != context.Canceled {
+		t.Errorf("expected cancellation to pass through, got: %v", err)
+	}
+}
@@ -0,0 +1,362 @@
+// Importer graph
+//
+// Before a breaking change, you want the blast radius: which repos import the
+// package, and from where. Mentions in comments, docs, and strings don't
+// count, so every file is parsed enough to find its real imports.
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	gotoken "go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var importersCmd = &cobra.Command{
+	Use:   "importers PACKAGE",
+	Short: "Show which repos import a package and from which directories",
+	Long: `
+Show which repos import a package and from which directories
+
+Files mentioning the package are fetched and parsed to confirm they really
+import it; mentions in comments and strings are ignored. Subpackages count
+too. Go, Python, JavaScript/TypeScript, Java, Kotlin, and Scala are understood.
+
+Use --format dot or json for a graph of repo -> imported package edges.
+
+	cs importers github.com/coxley/codesearch/cs
+	cs importers requests --lang python
+	cs importers @our/ui-kit --format dot | dot -Tsvg > blast.svg
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeImporters,
+}
+
+var importersFlags = struct {
+	format string
+}{}
+
+func init() {
+	importersCmd.Flags().StringVar(&importersFlags.format, "format", "table", "output as table, csv, json, or dot")
+	rootCmd.AddCommand(importersCmd)
+}
+
+// importer is a directory of a repo importing a package
+type importer struct {
+	Repo     string `json:"repo"`
+	Dir      string `json:"dir"`
+	Imported string `json:"imported"`
+	Files    int    `json:"files"`
+}
+
+func executeImporters(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+	pkg := args[0]
+
+	query := makeQuery([]string{strconv.Quote(pkg)})
+	if flags.showQuery {
+		fmt.Println(query)
+		return nil
+	}
+	v("Query: %s", query)
+
+	res, err := performSearch(ctx, query, flags.limit)
+	if err != nil {
+		return err
+	}
+	searchResult := coerceResults(res)
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
+	}
+
+	importers := aggregateImporters(fullText, pkg)
+	switch importersFlags.format {
+	case "json":
+		err = writeJSON(importerGraph(importers))
+	case "dot":
+		fmt.Print(importerDOT(importers))
+	default:
+		rows := [][]string{}
+		for _, imp := range importers {
+			rows = append(rows, []string{imp.Repo, imp.Dir, imp.Imported, fmt.Sprint(imp.Files)})
+		}
+		err = writeRows(importersFlags.format, []string{"REPO", "DIR", "IMPORTS", "FILES"}, rows)
+	}
+	return err
+}
+
+// aggregateImporters counts importing files per repo, directory, and imported
+// package
+func aggregateImporters(fullText FullText, pkg string) []importer {
+	counts := map[importer]int{}
+	for key, content := range fullText.Values {
+		imports, ok := findImports(key.Path, content)
+		if !ok {
+			continue
+		}
+		seen := map[string]struct{}{}
+		for _, imp := range imports {
+			if _, ok := seen[imp]; ok || !importsPackage(key.Path, imp, pkg) {
+				continue
+			}
+			seen[imp] = struct{}{}
+			dir := path.Dir(key.Path)
+			counts[importer{Repo: key.RepoString(), Dir: dir, Imported: imp}]++
+		}
+	}
+
+	importers := []importer{}
+	for imp, n := range counts {
+		imp.Files = n
+		importers = append(importers, imp)
+	}
+	sort.Slice(importers, func(i, j int) bool {
+		a, b := importers[i], importers[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Dir != b.Dir {
+			return a.Dir < b.Dir
+		}
+		return a.Imported < b.Imported
+	})
+	return importers
+}
+
+type graph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+type graphNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+type graphEdge struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Files int      `json:"files"`
+	Dirs  []string `json:"dirs"`
+}
+
+// importerGraph collapses directories into repo -> package edges
+func importerGraph(importers []importer) graph {
+	g := graph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+	nodes := map[string]struct{}{}
+	addNode := func(id, kind string) {
+		if _, ok := nodes[kind+id]; !ok {
+			nodes[kind+id] = struct{}{}
+			g.Nodes = append(g.Nodes, graphNode{id, kind})
+		}
+	}
+
+	edges := map[[2]string]int{}
+	for _, imp := range importers {
+		addNode(imp.Repo, "repo")
+		addNode(imp.Imported, "package")
+
+		pair := [2]string{imp.Repo, imp.Imported}
+		i, ok := edges[pair]
+		if !ok {
+			i = len(g.Edges)
+			edges[pair] = i
+			g.Edges = append(g.Edges, graphEdge{From: imp.Repo, To: imp.Imported, Dirs: []string{}})
+		}
+		g.Edges[i].Files += imp.Files
+		g.Edges[i].Dirs = append(g.Edges[i].Dirs, imp.Dir)
+	}
+	return g
+}
+
+func importerDOT(importers []importer) string {
+	var b strings.Builder
+	g := importerGraph(importers)
+	b.WriteString("digraph importers {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		if n.Kind == "package" {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&b, "\t%s [shape=%s];\n", strconv.Quote(n.ID), shape)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s [label=%q];\n", strconv.Quote(e.From), strconv.Quote(e.To), fmt.Sprint(e.Files))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// importsPackage reports if an import is the package or one under it
+//
+// Python and the JVM separate with dots, everything else with slashes.
+func importsPackage(filename, imp, pkg string) bool {
+	sep := "/"
+	switch path.Ext(filename) {
+	case ".py", ".pyi", ".java", ".kt", ".kts", ".scala":
+		sep = "."
+	}
+	return imp == pkg || strings.HasPrefix(imp, pkg+sep)
+}
+
+var (
+	pyImportRe     = regexp.MustCompile(`(?m)^[ \t]*import[ \t]+([^\n]+)`)
+	pyFromImportRe = regexp.MustCompile(`(?m)^[ \t]*from[ \t]+([\w.]+)[ \t]+import\b`)
+	jsImportRe     = regexp.MustCompile(`(?:\bimport\s*(?:[\w*{}\s,$]+\s*from\s*)?|\bexport\s*[\w*{}\s,$]*\s*from\s*|\brequire\s*\(\s*|\bimport\s*\(\s*)['"]([^'"\n]+)['"]`)
+	jvmImportRe    = regexp.MustCompile(`(?m)^[ \t]*import[ \t]+(?:static[ \t]+)?([\w.]+)`)
+)
+
+// findImports of a file, with false if we don't understand its language
+func findImports(filename, content string) ([]string, bool) {
+	imports := []string{}
+	switch path.Ext(filename) {
+	case ".go":
+		f, err := parser.ParseFile(gotoken.NewFileSet(), filename, content, parser.ImportsOnly)
+		if err != nil {
+			v("couldn't parse %s: %v", filename, err)
+			return nil, false
+		}
+		for _, imp := range f.Imports {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+				imports = append(imports, p)
+			}
+		}
+	case ".py", ".pyi":
+		code := blankComments(content, pythonSyntax, false)
+		for _, m := range pyImportRe.FindAllStringSubmatch(code, -1) {
+			// import a.b as c, d
+			for _, item := range strings.Split(m[1], ",") {
+				if fields := strings.Fields(item); len(fields) > 0 {
+					imports = append(imports, fields[0])
+				}
+			}
+		}
+		for _, m := range pyFromImportRe.FindAllStringSubmatch(code, -1) {
+			imports = append(imports, m[1])
+		}
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		code := blankComments(content, jsSyntax, true)
+		for _, m := range jsImportRe.FindAllStringSubmatch(code, -1) {
+			imports = append(imports, m[1])
+		}
+	case ".java", ".kt", ".kts", ".scala":
+		code := blankComments(content, cSyntax, false)
+		for _, m := range jvmImportRe.FindAllStringSubmatch(code, -1) {
+			imports = append(imports, strings.TrimSuffix(m[1], "."))
+		}
+	default:
+		return nil, false
+	}
+	return imports, true
+}
+
+// syntax is just enough of a language to find its comments and strings
+type syntax struct {
+	lineComment string
+	blockStart  string
+	blockEnd    string
+	// Longest first so """ wins over "
+	quotes []string
+}
+
+var (
+	cSyntax      = syntax{"//", "/*", "*/", []string{`"""`, `"`, `'`}}
+	jsSyntax     = syntax{"//", "/*", "*/", []string{"`", `"`, `'`}}
+	pythonSyntax = syntax{"#", "", "", []string{`"""`, `'''`, `"`, `'`}}
+)
+
+// blankComments replaces comments with spaces so offsets and line numbers
+// stay put
+//
+// Strings are blanked too unless they need to be kept, like JavaScript's
+// module specifiers. It's a scanner, not a parser: good enough to keep
+// "import foo" in a docstring from counting.
+func blankComments(src string, s syntax, keepStrings bool) string {
+	out := []byte(src)
+	blank := func(from, to int) {
+		for i := from; i < to && i < len(out); i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		switch {
+		case s.lineComment != "" && strings.HasPrefix(rest, s.lineComment):
+			end := strings.IndexByte(rest, '\n')
+			if end == -1 {
+				end = len(rest)
+			}
+			blank(i, i+end)
+			i += end
+			continue
+		case s.blockStart != "" && strings.HasPrefix(rest, s.blockStart):
+			end := strings.Index(rest[len(s.blockStart):], s.blockEnd)
+			if end == -1 {
+				end = len(rest)
+			} else {
+				end += len(s.blockStart) + len(s.blockEnd)
+			}
+			blank(i, i+end)
+			i += end
+			continue
+		}
+
+		var quote string
+		for _, q := range s.quotes {
+			if strings.HasPrefix(rest, q) {
+				quote = q
+				break
+			}
+		}
+		if quote == "" {
+			i++
+			continue
+		}
+
+		// Single-character quotes other than backticks can't span lines
+		multiline := len(quote) > 1 || quote == "`"
+		j := len(quote)
+		for j < len(rest) {
+			if rest[j] == '\\' {
+				j += 2
+				continue
+			}
+			if strings.HasPrefix(rest[j:], quote) {
+				j += len(quote)
+				break
+			}
+			if rest[j] == '\n' && !multiline {
+				break
+			}
+			j++
+		}
+		if !keepStrings {
+			blank(i+len(quote), i+j-len(quote))
+		}
+		i += j
+	}
+	return string(out)
+}
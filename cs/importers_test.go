@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestFindImports(t *testing.T) {
+	type data struct {
+		filename string
+		content  string
+		expected []string
+	}
+
+	td := []data{
+		{"main.go", "package main\n\n// import \"fake\"\nimport (\n\t\"fmt\"\n\tv \"github.com/spf13/viper\"\n)\n\nvar s = `import \"nope\"`\n", []string{"fmt", "github.com/spf13/viper"}},
+		{"app.py", "\"\"\"\nimport docstring\n\"\"\"\nimport os, requests.adapters as ra\nfrom requests import get  # import commented\ns = 'import strung'\n", []string{"os", "requests.adapters", "requests"}},
+		{"app.ts", "// import x from 'commented'\nimport React, { useState } from 'react';\nimport {\n  a,\n} from \"@our/ui-kit/button\";\nconst fs = require('fs');\n/* require('blocked') */\n", []string{"react", "@our/ui-kit/button", "fs"}},
+		{"App.java", "package x;\n\nimport static org.junit.Assert.*;\nimport com.our.lib.Client;\n/*\nimport com.fake.Thing;\n*/\n", []string{"org.junit.Assert", "com.our.lib.Client"}},
+	}
+	for _, test := range td {
+		imports, ok := findImports(test.filename, test.content)
+		if !ok {
+			t.Fatalf("%s: language not understood", test.filename)
+		}
+		if slices.Compare(imports, test.expected) != 0 {
+			t.Errorf("%s: expected: %q, got: %q", test.filename, test.expected, imports)
+		}
+	}
+
+	if _, ok := findImports("README.md", "import foo"); ok {
+		t.Errorf("expected markdown to not be understood")
+	}
+}
+
+func TestImportsPackage(t *testing.T) {
+	td := []struct {
+		filename, imp, pkg string
+		expected           bool
+	}{
+		{"a.go", "github.com/our/lib", "github.com/our/lib", true},
+		{"a.go", "github.com/our/lib/sub", "github.com/our/lib", true},
+		{"a.go", "github.com/our/library", "github.com/our/lib", false},
+		{"a.py", "requests.adapters", "requests", true},
+		{"a.py", "requests_oauth", "requests", false},
+		{"a.ts", "@our/ui-kit/button", "@our/ui-kit", true},
+	}
+	for _, test := range td {
+		if got := importsPackage(test.filename, test.imp, test.pkg); got != test.expected {
+			t.Errorf("%+v: got %v", test, got)
+		}
+	}
+}
+
+func TestAggregateImporters(t *testing.T) {
+	fullText := FullText{Values: map[FileKey]string{
+		{Owner: "o", Name: "a", Path: "cmd/x/main.go"}:  "package main\nimport \"github.com/our/lib\"\n",
+		{Owner: "o", Name: "a", Path: "cmd/x/other.go"}: "package main\nimport \"github.com/our/lib\"\n",
+		{Owner: "o", Name: "b", Path: "main.go"}:        "package main\n// see github.com/our/lib\n",
+	}}
+	importers := aggregateImporters(fullText, "github.com/our/lib")
+	if len(importers) != 1 || importers[0].Files != 2 || importers[0].Dir != "cmd/x" {
+		t.Errorf("unexpected importers: %+v", importers)
+	}
+
+	g := importerGraph(importers)
+	if len(g.Nodes) != 2 || len(g.Edges) != 1 || g.Edges[0].Files != 2 {
+		t.Errorf("unexpected graph: %+v", g)
+	}
+}
@@ -0,0 +1,335 @@
+// CI and container inventory
+//
+// "Which repos are still on actions/checkout@v2?" and "who builds from
+// golang:1.17?" come up whenever something is deprecated or has a CVE. These
+// searches parse the workflows and Dockerfiles themselves rather than trusting
+// text matches.
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory actions|images [name[@ref|:tag]]",
+	Short: "Inventory GitHub Actions or Docker base images used across repos",
+	Long: `
+Inventory GitHub Actions or Docker base images used across repos
+
+'actions' parses 'uses:' of jobs and steps in .github/workflows. 'images'
+parses FROM lines of files named Dockerfile, resolving ARG defaults and skipping
+build stages. Results are grouped by action or image, then version, then repo.
+
+Filter to one action or image, optionally pinning the ref or tag:
+
+	cs inventory actions
+	cs inventory actions actions/checkout
+	cs inventory actions actions/checkout@v2
+	cs inventory images golang:1.17 --format csv
+	`,
+	Args:      cobra.RangeArgs(1, 2),
+	ValidArgs: []string{"actions", "images"},
+	RunE:      executeInventory,
+}
+
+var inventoryFlags = struct {
+	format string
+}{}
+
+func init() {
+	inventoryCmd.Flags().StringVar(&inventoryFlags.format, "format", "table", "output as table, json, or csv")
+	rootCmd.AddCommand(inventoryCmd)
+}
+
+// reference is one use of an action or image
+type reference struct {
+	name    string
+	version string
+}
+
+// inventoryKind knows how to search for and parse one kind of reference
+type inventoryKind struct {
+	qualifiers []string
+	matches    func(filename string) bool
+	parse      func(content string) ([]reference, error)
+	// filter from the command line: actions/checkout@v3, golang:1.19
+	filter func(s string) reference
+}
+
+var inventoryKinds = map[string]inventoryKind{
+	"actions": {
+		qualifiers: []string{"uses", "path:.github/workflows"},
+		matches: func(f string) bool {
+			ext := path.Ext(f)
+			return path.Dir(f) == ".github/workflows" && (ext == ".yml" || ext == ".yaml")
+		},
+		parse: parseWorkflow,
+		filter: func(s string) reference {
+			return splitReference(s, "@")
+		},
+	},
+	"images": {
+		qualifiers: []string{"FROM", "filename:Dockerfile"},
+		// Only what the filename qualifier finds: Dockerfile.prod and
+		// app.dockerfile need searches of their own
+		matches: func(f string) bool {
+			return strings.EqualFold(path.Base(f), "Dockerfile")
+		},
+		parse:  parseDockerfile,
+		filter: imageFilter,
+	},
+}
+
+type inventoryGroup struct {
+	Name     string             `json:"name"`
+	Versions []inventoryVersion `json:"versions"`
+}
+
+type inventoryVersion struct {
+	Version string   `json:"version"`
+	Repos   []string `json:"repos"`
+}
+
+func executeInventory(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+
+	kind, ok := inventoryKinds[args[0]]
+	if !ok {
+		return fmt.Errorf("expected 'actions' or 'images', got: %s", args[0])
+	}
+
+	var filter reference
+	terms := kind.qualifiers
+	if len(args) == 2 {
+		filter = kind.filter(args[1])
+		terms = append([]string{strconv.Quote(filter.name)}, terms...)
+	}
+
+	query := makeQuery(terms)
+	if flags.showQuery {
+		fmt.Println(query)
+		return nil
+	}
+	v("Query: %s", query)
+
+	res, total, err := performCountedSearch(ctx, query, flags.limit)
+	if err != nil {
+		return err
+	}
+	warnIfCapped("", len(res), total)
+	searchResult := coerceResults(res)
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
+	}
+
+	found := map[reference]map[string]struct{}{}
+	for key, content := range fullText.Values {
+		if !kind.matches(key.Path) {
+			continue
+		}
+		refs, err := kind.parse(content)
+		if err != nil {
+			v("couldn't parse %s: %v", key.String(), err)
+			continue
+		}
+		for _, ref := range refs {
+			if filter.name != "" && !strings.EqualFold(ref.name, filter.name) {
+				continue
+			}
+			if filter.version != "" && ref.version != filter.version {
+				continue
+			}
+			if found[ref] == nil {
+				found[ref] = map[string]struct{}{}
+			}
+			found[ref][key.RepoString()] = struct{}{}
+		}
+	}
+
+	groups := groupInventory(found)
+	switch inventoryFlags.format {
+	case "json":
+		err = writeJSON(groups)
+	default:
+		rows := [][]string{}
+		for _, g := range groups {
+			for _, ver := range g.Versions {
+				rows = append(rows, []string{g.Name, ver.Version, fmt.Sprint(len(ver.Repos)), strings.Join(ver.Repos, ", ")})
+			}
+		}
+		err = writeRows(inventoryFlags.format, []string{"NAME", "VERSION", "COUNT", "REPOS"}, rows)
+	}
+	return err
+}
+
+// splitReference on the last separator: actions/checkout@v3, golang:1.19
+//
+// Registry ports (localhost:5000/app) aren't mistaken for tags.
+func splitReference(s, sep string) reference {
+	i := strings.LastIndex(s, sep)
+	if i == -1 || strings.Contains(s[i:], "/") {
+		return reference{name: s}
+	}
+	return reference{name: s[:i], version: s[i+1:]}
+}
+
+// groupInventory by name, then version with the newest first
+func groupInventory(found map[reference]map[string]struct{}) []inventoryGroup {
+	byName := map[string][]inventoryVersion{}
+	for ref, repos := range found {
+		ver := inventoryVersion{Version: ref.version, Repos: []string{}}
+		for repo := range repos {
+			ver.Repos = append(ver.Repos, repo)
+		}
+		sort.Strings(ver.Repos)
+		byName[ref.name] = append(byName[ref.name], ver)
+	}
+
+	groups := []inventoryGroup{}
+	for name, versions := range byName {
+		sort.Slice(versions, func(i, j int) bool {
+			vi, vj := extractVersion(versions[i].Version), extractVersion(versions[j].Version)
+			if c := compareVersions(vi, vj); c != 0 {
+				return c > 0
+			}
+			return versions[i].Version < versions[j].Version
+		})
+		groups = append(groups, inventoryGroup{Name: name, Versions: versions})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// parseWorkflow for the 'uses' of jobs (reusable workflows) and their steps
+//
+// Local actions (./path) and docker:// references aren't versioned like the
+// rest so they're left out.
+func parseWorkflow(content string) ([]reference, error) {
+	var wf struct {
+		Jobs map[string]struct {
+			Uses  string
+			Steps []struct {
+				Uses string
+			}
+		}
+	}
+	if err := yaml.Unmarshal([]byte(content), &wf); err != nil {
+		return nil, err
+	}
+
+	refs := []reference{}
+	add := func(uses string) {
+		uses = strings.TrimSpace(uses)
+		if uses == "" || strings.HasPrefix(uses, "./") || strings.HasPrefix(uses, "docker://") {
+			return
+		}
+		refs = append(refs, splitReference(uses, "@"))
+	}
+	for _, job := range wf.Jobs {
+		add(job.Uses)
+		for _, step := range job.Steps {
+			add(step.Uses)
+		}
+	}
+	return refs, nil
+}
+
+var (
+	dockerArgRe  = regexp.MustCompile(`(?i)^ARG\s+(\w+)(?:=(\S*))?`)
+	dockerFromRe = regexp.MustCompile(`(?i)^FROM\s+(?:--\S+\s+)*(\S+)(?:\s+AS\s+(\S+))?`)
+	dockerVarRe  = regexp.MustCompile(`\$\{?(\w+)(?::-([^}]*))?\}?`)
+)
+
+// parseDockerfile for base images of every stage
+//
+// ARG defaults are substituted, and FROM lines naming an earlier stage or
+// scratch aren't images.
+func parseDockerfile(content string) ([]reference, error) {
+	// Join continuation lines so each instruction is on one line
+	content = strings.ReplaceAll(content, "\\\r\n", " ")
+	content = strings.ReplaceAll(content, "\\\n", " ")
+
+	args := map[string]string{}
+	stages := map[string]struct{}{"scratch": {}}
+	refs := []reference{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if m := dockerArgRe.FindStringSubmatch(line); m != nil {
+			if _, ok := args[m[1]]; !ok {
+				args[m[1]] = m[2]
+			}
+			continue
+		}
+
+		m := dockerFromRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		image := dockerVarRe.ReplaceAllStringFunc(m[1], func(s string) string {
+			sub := dockerVarRe.FindStringSubmatch(s)
+			if val := args[sub[1]]; val != "" {
+				return val
+			}
+			return sub[2]
+		})
+		if m[2] != "" {
+			stages[strings.ToLower(m[2])] = struct{}{}
+		}
+		if _, ok := stages[strings.ToLower(image)]; ok || image == "" {
+			continue
+		}
+		refs = append(refs, normalizeImage(image))
+	}
+	return refs, nil
+}
+
+// imageFilter normalized like the images it's compared with, but without
+// defaulting to latest: no tag means every tag
+func imageFilter(s string) reference {
+	ref := normalizeImage(s)
+	if !strings.Contains(s, "@") && splitReference(s, ":").version == "" {
+		ref.version = ""
+	}
+	return ref
+}
+
+// normalizeImage so golang, library/golang, and docker.io/library/golang:latest
+// are the same
+func normalizeImage(image string) reference {
+	var ref reference
+	if i := strings.Index(image, "@"); i != -1 {
+		ref = reference{name: image[:i], version: image[i+1:]}
+	} else {
+		ref = splitReference(image, ":")
+	}
+	ref.name = strings.TrimPrefix(ref.name, "docker.io/")
+	ref.name = strings.TrimPrefix(ref.name, "library/")
+	if ref.version == "" {
+		ref.version = "latest"
+	}
+	return ref
+}
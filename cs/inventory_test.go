@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseWorkflow(t *testing.T) {
+	content := `
+name: ci
+on: [push]
+jobs:
+  shared:
+    uses: our/workflows/.github/workflows/lint.yml@main
+  test:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v3
+      - uses: ./local-action
+      - run: 'echo uses: fake/action@v1'
+      - uses: actions/setup-go@v3.3.0
+`
+	refs, err := parseWorkflow(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[reference]bool{
+		{"our/workflows/.github/workflows/lint.yml", "main"}: true,
+		{"actions/checkout", "v3"}:                           true,
+		{"actions/setup-go", "v3.3.0"}:                       true,
+	}
+	if len(refs) != len(expected) {
+		t.Fatalf("expected: %v, got: %v", expected, refs)
+	}
+	for _, ref := range refs {
+		if !expected[ref] {
+			t.Errorf("unexpected reference: %v", ref)
+		}
+	}
+}
+
+func TestParseDockerfile(t *testing.T) {
+	content := `ARG GO_VERSION=1.19
+# FROM commented:out
+FROM --platform=linux/amd64 golang:${GO_VERSION} AS builder
+RUN go build
+FROM builder AS test
+FROM docker.io/library/alpine
+FROM scratch
+FROM localhost:5000/app@sha256:abc \
+  AS final
+`
+	refs, err := parseDockerfile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []reference{
+		{"golang", "1.19"},
+		{"alpine", "latest"},
+		{"localhost:5000/app", "sha256:abc"},
+	}
+	if len(refs) != len(expected) {
+		t.Fatalf("expected: %v, got: %v", expected, refs)
+	}
+	for i := range expected {
+		if refs[i] != expected[i] {
+			t.Errorf("expected: %v, got: %v", expected[i], refs[i])
+		}
+	}
+}
+
+func TestImageFilter(t *testing.T) {
+	td := []struct {
+		filter   string
+		expected reference
+	}{
+		{"golang", reference{"golang", ""}},
+		{"docker.io/library/golang", reference{"golang", ""}},
+		{"library/golang:1.17", reference{"golang", "1.17"}},
+		{"localhost:5000/app", reference{"localhost:5000/app", ""}},
+		{"localhost:5000/app@sha256:abc", reference{"localhost:5000/app", "sha256:abc"}},
+	}
+	for _, tc := range td {
+		if got := imageFilter(tc.filter); got != tc.expected {
+			t.Errorf("%q: expected: %v, got: %v", tc.filter, tc.expected, got)
+		}
+	}
+}
+
+func TestGroupInventory(t *testing.T) {
+	found := map[reference]map[string]struct{}{
+		{"actions/checkout", "v2"}: {"o/b": {}},
+		{"actions/checkout", "v3"}: {"o/a": {}, "o/c": {}},
+	}
+	groups := groupInventory(found)
+	if len(groups) != 1 || len(groups[0].Versions) != 2 {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	if v := groups[0].Versions[0]; v.Version != "v3" || len(v.Repos) != 2 {
+		t.Errorf("expected newest version first, got: %+v", v)
+	}
+}
@@ -8,10 +8,14 @@
 // - Fetch file contents for every returned Path
 // - Overlay colorized text matches onto the contents
 // - Write each line to stdout
+//
+// Each page of search results goes through these steps as soon as it
+// arrives, unless --sort asks to wait for all of them. (see stream.go)
 package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
@@ -38,7 +42,7 @@ var rootCmd = &cobra.Command{
 	has consistent branch names, consider running 'cs set-default-branch' to
 	alleviate some pressure.
 	`,
-	Run:  execute,
+	RunE: execute,
 	Args: cobra.MinimumNArgs(1),
 }
 
@@ -59,9 +63,16 @@ var flags = struct {
 	onlyFiles     bool
 	onlyRepos     bool
 	onlyFullNames bool
+	groupBy       string
+	blame         bool
+	olderThan     string
+	newerThan     string
 	contentOnly   bool
+	showFunction  bool
+	funcContext   bool
 	urlPrefix     bool
 	greppable     bool
+	sortOutput    bool
 	forceColor    bool
 
 	cfgFile   string
@@ -70,51 +81,89 @@ var flags = struct {
 	dumpData  bool
 	tabWidth  int
 
-	baseURL string
+	saveSnapshot string
+
+	baseURL     string
+	noWait      bool
+	concurrency int
+	shardBy     string
+	maxFileSize int64
+	noCache     bool
+	refresh     bool
+	offline     bool
 
 	// includeArchived bool
 }{}
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	var maxFileSize string
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		var err error
+		if flags.maxFileSize, err = parseSize(maxFileSize); err != nil {
+			return fmt.Errorf("invalid --max-filesize: %w", err)
+		}
+		return initConfig()
+	}
 	rootCmd.CompletionOptions.HiddenDefaultCmd = true
-
-	rootCmd.Flags().IntVar(&flags.limit, "limit", 30, "limit the number of matches queried and displayed")
-
-	rootCmd.Flags().StringVarP(&flags.org, "org", "o", "", "scope search with a single organization:[org]")
-	rootCmd.Flags().StringVarP(&flags.repo, "repo", "r", "", "scope search to the given repo, filling in [org] for you if configured")
-	rootCmd.Flags().StringVar(&flags.lang, "lang", "", "scope search with a single language:[lang]")
-	rootCmd.Flags().StringVarP(&flags.filename, "filename", "f", "", "scope search by filename")
-	rootCmd.Flags().StringVarP(&flags.path, "path", "p", "", "scope search by the path files are in")
-	rootCmd.Flags().StringVarP(&flags.ext, "ext", "x", "", "scope search by file extension")
-
-	rootCmd.Flags().IntVarP(&flags.after, "after-context", "A", 0, "print [num] lines of trailing context after each match")
-	rootCmd.Flags().IntVarP(&flags.before, "before-context", "B", 0, "print [num] lines of leading context before each match")
-	rootCmd.Flags().IntVarP(&flags.context, "context", "C", 0, "print [num] lines of context before and after each match")
+	// main reports errors, and usage only helps when flags or args are wrong
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w: see '%s --help'", err, cmd.CommandPath())
+	})
+
+	// Scope, output, and plumbing flags are shared with subcommands like 'refs'
+	rootCmd.PersistentFlags().IntVar(&flags.limit, "limit", 30, "limit the number of matches queried and displayed")
+
+	rootCmd.PersistentFlags().StringVarP(&flags.org, "org", "o", "", "scope search with a single organization:[org]")
+	rootCmd.PersistentFlags().StringVarP(&flags.repo, "repo", "r", "", "scope search to the given repo, filling in [org] for you if configured")
+	rootCmd.PersistentFlags().StringVar(&flags.lang, "lang", "", "scope search with a single language:[lang]")
+	rootCmd.PersistentFlags().StringVarP(&flags.filename, "filename", "f", "", "scope search by filename")
+	rootCmd.PersistentFlags().StringVarP(&flags.path, "path", "p", "", "scope search by the path files are in")
+	rootCmd.PersistentFlags().StringVarP(&flags.ext, "ext", "x", "", "scope search by file extension")
+
+	rootCmd.PersistentFlags().IntVarP(&flags.after, "after-context", "A", 0, "print [num] lines of trailing context after each match")
+	rootCmd.PersistentFlags().IntVarP(&flags.before, "before-context", "B", 0, "print [num] lines of leading context before each match")
+	rootCmd.PersistentFlags().IntVarP(&flags.context, "context", "C", 0, "print [num] lines of context before and after each match")
+	rootCmd.PersistentFlags().BoolVar(&flags.showFunction, "show-function", false, "show the function, method, or type each match is in")
+	rootCmd.PersistentFlags().BoolVarP(&flags.funcContext, "function-context", "W", false, "print the whole function, method, or type each match is in")
 	rootCmd.Flags().BoolVarP(&flags.count, "count", "c", false, "print only a count of matches")
 
 	rootCmd.Flags().BoolVarP(&flags.onlyFiles, "files-only", "l", false, "print only filenames of matches to stdout")
 	rootCmd.Flags().BoolVar(&flags.onlyRepos, "repos-only", false, "print only repository names containing matches to stdout")
 	rootCmd.Flags().BoolVar(&flags.onlyFullNames, "full-names-only", false, "print only fully-qualified repo names to stdout (your/repo path/to/README.md)")
 	rootCmd.Flags().BoolVar(&flags.contentOnly, "content", false, "print only the text results, nothing else")
-	rootCmd.Flags().BoolVarP(&flags.urlPrefix, "url-prefix", "u", false, "print urls instead of repo:file/path")
-	rootCmd.Flags().BoolVarP(&flags.greppable, "greppable", "G", false, "print each match with its filename on the same line")
-	rootCmd.Flags().BoolVar(&flags.forceColor, "force-color", false, "print ANSI sequences even if input or output aren't standard streams")
-
-	rootCmd.Flags().StringVar(&flags.cfgFile, "config", "", "overrides location of the config file")
-	rootCmd.Flags().BoolVarP(&flags.verbose, "verbose", "v", false, "prints verbose messages to stderr for debugging")
-	rootCmd.Flags().BoolVarP(&flags.showQuery, "show-query", "q", false, "show the search terms we would send to GitHub and exit")
+	rootCmd.Flags().StringVar(&flags.groupBy, "group-by", "", "group matches by 'owner' using each repo's CODEOWNERS")
+	rootCmd.Flags().BoolVar(&flags.blame, "blame", false, "show the author, date, and commit of the last change to each line")
+	rootCmd.Flags().StringVar(&flags.olderThan, "older-than", "", "only show matches last changed before a date (2006-01-02) or age (90d, 6w, 18m, 2y)")
+	rootCmd.Flags().StringVar(&flags.newerThan, "newer-than", "", "only show matches last changed after a date (2006-01-02) or age (90d, 6w, 18m, 2y)")
+	rootCmd.PersistentFlags().BoolVarP(&flags.urlPrefix, "url-prefix", "u", false, "print urls instead of repo:file/path")
+	rootCmd.PersistentFlags().BoolVarP(&flags.greppable, "greppable", "G", false, "print each match with its filename on the same line")
+	rootCmd.Flags().BoolVar(&flags.sortOutput, "sort", false, "wait for every result and print them sorted by repo and path")
+	rootCmd.PersistentFlags().BoolVar(&flags.forceColor, "force-color", false, "print ANSI sequences even if input or output aren't standard streams")
+
+	rootCmd.PersistentFlags().StringVar(&flags.cfgFile, "config", "", "overrides location of the config file")
+	rootCmd.PersistentFlags().BoolVarP(&flags.verbose, "verbose", "v", false, "prints verbose messages to stderr for debugging")
+	rootCmd.PersistentFlags().BoolVarP(&flags.showQuery, "show-query", "q", false, "show the search terms we would send to GitHub and exit")
 	rootCmd.Flags().BoolVar(&flags.dumpData, "dump", false, "dump result structures to stdout")
+	rootCmd.Flags().StringVar(&flags.saveSnapshot, "save-snapshot", "", "save results to a JSON file for 'cs render' to replay offline")
 
-	rootCmd.Flags().IntVar(&flags.tabWidth, "tabwidth", 2, "number of spaces to display tabs as")
+	rootCmd.PersistentFlags().IntVar(&flags.tabWidth, "tabwidth", 2, "number of spaces to display tabs as")
 
-	rootCmd.Flags().StringVar(&flags.baseURL, "base-url", "https://api.github.com/", "base url for api endpoint")
+	rootCmd.PersistentFlags().StringVar(&flags.baseURL, "base-url", "https://api.github.com/", "base url for api endpoint")
+	rootCmd.PersistentFlags().BoolVar(&flags.noWait, "no-wait", false, "fail instead of waiting when GitHub rate limits us")
+	rootCmd.PersistentFlags().BoolVar(&flags.noCache, "no-cache", false, "don't keep or revalidate GitHub responses on disk")
+	rootCmd.PersistentFlags().BoolVar(&flags.refresh, "refresh", false, "fetch everything again instead of revalidating cached responses")
+	rootCmd.Flags().BoolVar(&flags.offline, "offline", false, "answer from the last time this search ran online, without GitHub")
+	rootCmd.PersistentFlags().StringVar(&maxFileSize, "max-filesize", "10M", "fetch files GitHub truncates in full up to this size, and show its excerpts past it")
+	rootCmd.PersistentFlags().StringVar(&flags.shardBy, "shard-by", "size", "split searches past GitHub's 1000 result cap by 'size', 'repo', or 'ext' first")
+	rootCmd.PersistentFlags().IntVar(&flags.concurrency, "concurrency", 4, "number of requests for file contents to run at once")
 
-	viper.BindPFlag("org", rootCmd.Flags().Lookup("org"))
+	viper.BindPFlag("org", rootCmd.PersistentFlags().Lookup("org"))
 	viper.BindPFlag("format", rootCmd.Flags().Lookup("format"))
-	viper.BindPFlag("tabwidth", rootCmd.Flags().Lookup("tabwidth"))
+	viper.BindPFlag("tabwidth", rootCmd.PersistentFlags().Lookup("tabwidth"))
 	viper.BindPFlag("url", rootCmd.Flags().Lookup("url"))
-	viper.BindPFlag("base-url", rootCmd.Flags().Lookup("base_url"))
+	viper.BindPFlag("base-url", rootCmd.PersistentFlags().Lookup("base_url"))
 
 	// TODO: have an interactive option that's just a glorified `less` with the
 	// ability to toggle fully-qualified repo + path + whatever metadata without
@@ -125,80 +174,168 @@ func init() {
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	var status exitStatus
+	if err != nil && !errors.As(err, &status) {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
 	}
+	os.Exit(exitCode(err))
 }
 
-func execute(cmd *cobra.Command, args []string) {
+func execute(cmd *cobra.Command, args []string) error {
 	if flags.forceColor {
 		color.NoColor = false
 	}
 	ctx := cmd.Context()
+	if flags.groupBy != "" && flags.groupBy != "owner" {
+		return fmt.Errorf("unsupported --group-by: %s", flags.groupBy)
+	}
+	olderThan, err := parseAge(flags.olderThan, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid --older-than: %w", err)
+	}
+	newerThan, err := parseAge(flags.newerThan, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid --newer-than: %w", err)
+	}
+
 	query := makeQuery(args)
 	if flags.showQuery {
 		fmt.Println(query)
-		return
+		return nil
 	}
 	v("Query: %s", query)
 
-	res, err := performSearch(ctx, query, flags.limit)
+	if flags.offline {
+		return executeOffline(query)
+	}
+
+	if flags.count {
+		total, err := countResults(ctx, query)
+		if err != nil {
+			return err
+		}
+		fmt.Println(total)
+		return matchStatus(total)
+	}
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
 	if err != nil {
-		fatalf(fmt.Sprint(err))
+		return err
 	}
 
-	httpClient := getAuthenticatedHTTP(ctx)
+	// Print each page as it comes in unless something needs every result
+	if !flags.sortOutput && flags.saveSnapshot == "" && !flags.dumpData && flags.groupBy == "" {
+		found, err := streamResults(ctx, httpClient, query, olderThan, newerThan)
+		if err != nil {
+			return err
+		}
+		return matchStatus(found)
+	}
 
+	res, err := performSearch(ctx, query, flags.limit)
+	if err != nil {
+		return err
+	}
 	searchResult := coerceResults(res)
 
-	// TODO: With pagination, it might make sense to do this as each result comes in
-	if flags.onlyFiles {
-		printFiles(searchResult)
-		return
+	// Snapshots need everything, so listings wait until it's fetched.
+	if flags.saveSnapshot == "" && printListing(searchResult) {
+		return matchStatus(len(searchResult))
 	}
 
-	if flags.onlyFullNames {
-		printFullNames(searchResult)
-		return
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
 	}
 
-	if flags.onlyRepos {
-		printRepos(searchResult)
-		return
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
 	}
+	recordSearch(query, searchResult, defaultBranches, fullText)
 
-	defaultBranches := getDefaultBranches(httpClient, searchResult)
-
-	var fullText FullText
-	if len(searchResult) >= 100 {
-		fullText = paginateFullText(httpClient, searchResult, defaultBranches)
-	} else {
-		fullText = getFullText(httpClient, searchResult, defaultBranches)
+	if flags.saveSnapshot != "" {
+		snap := newSnapshot(query, searchResult, defaultBranches, fullText)
+		if err := saveSnapshot(flags.saveSnapshot, snap); err != nil {
+			return fmt.Errorf("couldn't save snapshot: %w", err)
+		}
+		v("Saved snapshot to %s", flags.saveSnapshot)
+		if printListing(searchResult) {
+			return matchStatus(len(searchResult))
+		}
 	}
 
 	if flags.dumpData {
-		dumpData(searchResult, defaultBranches, fullText)
-		return
+		return dumpData(searchResult, defaultBranches, fullText)
 	}
 
 	matches := createMatches(searchResult, fullText, defaultBranches)
+	if flags.blame || !olderThan.IsZero() || !newerThan.IsZero() {
+		blames, err := getBlame(httpClient, matchedFiles(matches), defaultBranches)
+		if err != nil {
+			return err
+		}
+		annotateBlame(matches, blames)
+		if !olderThan.IsZero() || !newerThan.IsZero() {
+			matches = filterByAge(matches, olderThan, newerThan)
+		}
+	}
+	if flags.groupBy == "owner" {
+		rules, err := getCodeowners(httpClient, searchResult, defaultBranches)
+		if err != nil {
+			return err
+		}
+		annotateOwners(matches, rules)
+		printByOwner(matches)
+		return matchStatus(len(matches))
+	}
 
-	gstr := "$repo:$path:$lineno: $text"
+	printMatches(matches)
+	return matchStatus(len(matches))
+}
+
+// printMatches writes matches grouped under a header per file, or one per line
+// with --greppable.
+//
+// When a match knows the function it lives in, that's shown too: as a
+// sub-header when grouping, and as an extra field when greppable.
+func printMatches(matches []match) {
+	grepPrefix := "$repo:$path:$lineno:"
 	header := "$repo:$path ($branch)"
-	line := "$lineno: $text"
 	if flags.urlPrefix {
-		gstr = "$url_line: $text"
+		grepPrefix = "$url_line:"
 		header = "$url_file ($branch)"
 	}
 
+	binaryFile := "Binary file $repo:$path matches"
+	if flags.urlPrefix {
+		binaryFile = "Binary file $url_file matches"
+	}
+
 	// Depends on match being properly sorted by file => match
-	var prevFile string
+	var prevFile, prevFunc string
 	for _, m := range matches {
 
 		p := printer{m}
-		if flags.greppable {
-			fmt.Println(p.fmt(gstr))
+		if m.binary {
+			if !flags.greppable && prevFile != "" {
+				fmt.Println()
+			}
+			fmt.Println(p.fmt(binaryFile))
+			prevFile = m.path
+			continue
+		}
+		text := " $text"
+		if m.blame.sha != "" {
+			text = " $blame $text"
+		}
+
+		if flags.greppable && m.function != "" {
+			fmt.Println(p.fmt(grepPrefix + "$func:" + text))
+			continue
+		} else if flags.greppable {
+			fmt.Println(p.fmt(grepPrefix + text))
 			continue
 		}
 
@@ -206,11 +343,19 @@ func execute(cmd *cobra.Command, args []string) {
 		if m.path != prevFile && prevFile != "" {
 			fmt.Println()
 		}
-		if m.path != prevFile {
+		if m.path != prevFile && len(m.owners) > 0 {
+			fmt.Println(p.fmt(header + " $owners"))
+			prevFunc = ""
+		} else if m.path != prevFile {
 			fmt.Println(p.fmt(header))
+			prevFunc = ""
 		}
-		fmt.Println(p.fmt(line))
+		if m.function != "" && m.function != prevFunc {
+			fmt.Println(p.fmt("$func"))
+		}
+		fmt.Println(p.fmt("$lineno:" + text))
 		prevFile = m.path
+		prevFunc = m.function
 	}
 }
 
@@ -235,8 +380,8 @@ func (f *FileKey) RepoString() string {
 }
 
 type TextMatch struct {
-	Fragment string
-	Indices  [][2]int
+	Fragment string   `json:"fragment"`
+	Indices  [][2]int `json:"indices"`
 }
 
 type SearchResult map[FileKey][]TextMatch
@@ -280,17 +425,21 @@ func coerceResults(fromGitHub []*github.CodeResult) SearchResult {
 }
 
 func makeQuery(args []string) string {
+	return makeQueryIn(args, viper.GetString("org"), flags.repo)
+}
+
+// makeQueryIn an org and repo other than the configured ones
+func makeQueryIn(args []string, org, repo string) string {
 	var query string
 
-	org := viper.GetString("org")
 	if org != "" {
 		query += "org:" + org + " "
 	}
 
-	if flags.repo != "" && org != "" && !strings.Contains(flags.repo, "/") {
-		query += "repo:" + org + "/" + flags.repo + " "
-	} else if flags.repo != "" {
-		query += "repo:" + flags.repo + " "
+	if repo != "" && org != "" && !strings.Contains(repo, "/") {
+		query += "repo:" + org + "/" + repo + " "
+	} else if repo != "" {
+		query += "repo:" + repo + " "
 	}
 
 	if lang := flags.lang; lang != "" {
@@ -313,6 +462,38 @@ func makeQuery(args []string) string {
 }
 
 func performSearch(ctx context.Context, query string, limit int) ([]*github.CodeResult, error) {
+	results, _, err := performCountedSearch(ctx, query, limit)
+	return results, err
+}
+
+// performCountedSearch also returns how many files GitHub counted, which is
+// more than were returned when limit or the cap got in the way
+func performCountedSearch(ctx context.Context, query string, limit int) ([]*github.CodeResult, int, error) {
+	results := []*github.CodeResult{}
+	total, err := searchPages(ctx, query, limit, func(page []*github.CodeResult) error {
+		results = append(results, page...)
+		return nil
+	})
+	return results, total, err
+}
+
+// warnIfCapped when a search matched more files than were looked at, naming
+// the search when there's more than one
+func warnIfCapped(name string, got, total int) {
+	if got >= total {
+		return
+	}
+	if name != "" {
+		name += ": "
+	}
+	w("%sonly looked at %d of %d files: raise --limit for a complete picture", name, got, total)
+}
+
+// searchPages hands each page of results to fn as soon as it arrives, up to
+// limit results in total, and returns how many GitHub counted
+//
+// Past GitHub's cap, the search is sharded. (see shard.go)
+func searchPages(ctx context.Context, query string, limit int, fn func([]*github.CodeResult) error) (int, error) {
 	start := time.Now()
 	defer func() {
 		v("Performing search took %s", time.Since(start))
@@ -320,49 +501,38 @@ func performSearch(ctx context.Context, query string, limit int) ([]*github.Code
 
 	client, err := githubClient(ctx)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	v("User-Agent: %s", client.UserAgent)
 
-	opts := &github.SearchOptions{TextMatch: true}
-	if limit > 0 {
-		opts.PerPage = limit
+	s, err := newSearcher(client, query, limit, fn)
+	if err != nil {
+		return 0, err
 	}
+	err = s.run(ctx)
+	return s.total, err
+}
 
-	// GitHub only allows 100 results. We'll make things right with paging.
-	if opts.PerPage > 100 {
-		opts.PerPage = 100
+// countResults of a search without fetching them
+func countResults(ctx context.Context, query string) (int, error) {
+	client, err := githubClient(ctx)
+	if err != nil {
+		return 0, err
 	}
-
-	opts.Page = 1
-	remaining := limit
-	results := []*github.CodeResult{}
-	for remaining > 0 {
-		v("Page: %d", opts.Page)
+	opts := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 1}}
+	for {
 		res, _, err := client.Search.Code(ctx, query, opts)
-		if err != nil {
-			return nil, err
-		}
-
-		if flags.count {
-			fmt.Println(res.GetTotal())
-			os.Exit(0)
-		}
-
-		results = append(results, res.CodeResults...)
-		rx := len(res.CodeResults)
-		remaining -= rx
-		opts.Page += 1
-
-		soFar := limit - remaining
-		v("Fetched: %d/%d", soFar, res.GetTotal())
-		total := res.GetTotal()
-		if total <= soFar {
-			break
+		var rle *github.RateLimitError
+		if errors.As(err, &rle) {
+			if err := scheduler.waitUntil(ctx, "search", rle.Rate.Reset.Time); err != nil {
+				return 0, err
+			}
+			continue
+		} else if err != nil {
+			return 0, githubError(err)
 		}
+		return res.GetTotal(), nil
 	}
-
-	return results, nil
 }
 
 func indexAllByte(s string, c byte) []int {
@@ -390,6 +560,9 @@ func (p *printer) fmt(s string) string {
 	s = strings.ReplaceAll(s, "$url_file", p.get("url_file"))
 	s = strings.ReplaceAll(s, "$lineno", p.get("lineno"))
 	s = strings.ReplaceAll(s, "$colno", p.get("colno"))
+	s = strings.ReplaceAll(s, "$func", p.get("func"))
+	s = strings.ReplaceAll(s, "$owners", p.get("owners"))
+	s = strings.ReplaceAll(s, "$blame", p.get("blame"))
 	s = strings.ReplaceAll(s, "$text", p.get("text"))
 	return s
 }
@@ -407,9 +580,18 @@ func (p *printer) get(field string) string {
 	case "url_file":
 		return color.New(color.FgBlue).Sprint(p.lineURL())
 	case "lineno":
+		if p.excerpt {
+			return color.New(color.FgGreen).Sprint("?")
+		}
 		return color.New(color.FgGreen).Sprint(ansiURL(fmt.Sprint(p.lineno), p.lineURL()))
 	case "colno":
 		return color.New(color.FgGreen).Sprint(p.colno)
+	case "func":
+		return color.New(color.FgYellow).Sprint(p.function)
+	case "owners":
+		return color.New(color.FgMagenta).Sprint(strings.Join(p.owners, " "))
+	case "blame":
+		return color.New(color.Faint).Sprintf("%s %s %s", p.blame.sha, p.blame.author, p.blame.date.Format("2006-01-02"))
 	case "text":
 		return p.text
 	default:
@@ -435,6 +617,22 @@ type match struct {
 	lineno int
 	colno  int
 	text   string
+
+	// Name of the enclosing function, method, or type when it's known
+	function string
+	// Owning users and teams from CODEOWNERS when they're requested
+	owners []string
+	// Last change to the line when --blame is used
+	blame blameLine
+	// Line of the match this is shown for: the same as lineno unless it's
+	// context
+	matchLine int
+
+	// Found in GitHub's excerpt of a file too big to fetch, so lineno is
+	// only within the excerpt
+	excerpt bool
+	// The file is binary and there are no lines to show
+	binary bool
 }
 
 func (m *match) repoString() string {
@@ -483,6 +681,42 @@ func (f FileKeys) Less(i, j int) bool {
 }
 
 func createMatches(searchResult SearchResult, fullText FullText, defaultBranches map[string]string) []match {
+	matches, _ := createMatchesUpTo(searchResult, fullText, defaultBranches, flags.limit)
+	return matches
+}
+
+// matchOptions are the flags that shape matches, passed along explicitly so
+// callers can ask for something else without touching flags
+type matchOptions struct {
+	// Lines of context, -A/-B/-C combined
+	before, after int
+	// Find the enclosing function, and with funcContext show all of it
+	showFunction, funcContext bool
+	// Color matching text, unless color is off altogether
+	highlight bool
+}
+
+// flagMatchOptions are what the command line asked for
+func flagMatchOptions() matchOptions {
+	return matchOptions{
+		// Allow combining -C with flags -A and -B. The larger number just wins.
+		before:       max(flags.before, flags.context),
+		after:        max(flags.after, flags.context),
+		showFunction: flags.showFunction,
+		funcContext:  flags.funcContext,
+		highlight:    true,
+	}
+}
+
+// createMatchesUpTo shows at most limit fragments, reporting how many it did
+//
+// Streaming output calls this once per page with whatever's left of --limit.
+func createMatchesUpTo(searchResult SearchResult, fullText FullText, defaultBranches map[string]string, limit int) ([]match, int) {
+	return createMatchesWith(searchResult, fullText, defaultBranches, limit, flagMatchOptions())
+}
+
+// createMatchesWith options rather than flags
+func createMatchesWith(searchResult SearchResult, fullText FullText, defaultBranches map[string]string, limit int, opts matchOptions) ([]match, int) {
 	// Consistent sort.
 	// It's also easier to read when things gradually follow similar lines optically.
 	sortedKeys := []FileKey{}
@@ -497,18 +731,52 @@ func createMatches(searchResult SearchResult, fullText FullText, defaultBranches
 		textMatches := searchResult[key]
 		content := fullText.Values[key]
 
+		if err := fullText.Failed[key]; errors.Is(err, errNotOnBranch) {
+			w("%v: %s/%s %s", err, key.Owner, key.Name, key.Path)
+			continue
+		} else if err != nil {
+			// Already reported when fetching
+			v("skipping %s: %v", key.String(), err)
+			continue
+		}
+
+		if fullText.Binary[key] {
+			if limit > 0 && shown >= limit {
+				continue
+			}
+			shown++
+			matches = append(matches, match{
+				owner:  key.Owner,
+				repo:   key.Name,
+				branch: defaultBranches[key.RepoString()],
+				path:   key.Path,
+				binary: true,
+			})
+			continue
+		}
+
+		// Without the whole file, GitHub's excerpts are the best there is
+		excerpt := fullText.Truncated[key]
+		if excerpt {
+			w("%s/%s %s is too big to show in full: showing GitHub's excerpts without line numbers", key.Owner, key.Name, key.Path)
+			content = excerpts(textMatches)
+		}
+
+		var scopes []scope
+		if (opts.showFunction || opts.funcContext) && !excerpt {
+			scopes = fileScopes(key.Path, content)
+		}
+
+		fileStart := len(matches)
 		for _, tm := range textMatches {
 
-			if flags.limit > 0 && shown >= flags.limit {
+			if limit > 0 && shown >= limit {
 				break
 			}
 
 			// Locate fragment in full text
 			fragIdx := strings.Index(content, tm.Fragment)
-			if fragIdx == -1 && fullText.Truncated[key] {
-				w("file content truncated in GitHub reply: %s/%s %s", key.Owner, key.Name, key.Path)
-				continue
-			} else if fragIdx == -1 {
+			if fragIdx == -1 {
 				w("couldn't find search term in file content: %s/%s %s", key.Owner, key.Name, key.Path)
 				v("search term:%v", tm.Fragment)
 				continue
@@ -538,7 +806,10 @@ func createMatches(searchResult SearchResult, fullText FullText, defaultBranches
 				j += fragIdx + ansiOverhead
 				startPositions = append(startPositions, i)
 
-				highlight := color.New(color.FgRed, color.Bold).Sprint(content[i:j])
+				highlight := content[i:j]
+				if opts.highlight {
+					highlight = color.New(color.FgRed, color.Bold).Sprint(content[i:j])
+				}
 				content = content[:i] + highlight + content[j:]
 
 				// Account for byte overhead as we're adding ANSI sequences
@@ -595,11 +866,19 @@ func createMatches(searchResult SearchResult, fullText FullText, defaultBranches
 
 				// Check if we need to show any extra lines contextual to the
 				// matching one.
-				//
-				// Allow combining -C with flags -A and -B. The larger number just wins.
 				var leading, trailing []string
-				before := max(flags.before, flags.context)
-				after := max(flags.after, flags.context)
+				before, after := opts.before, opts.after
+				// Neighboring excerpts aren't neighboring lines
+				if excerpt {
+					before, after = 0, 0
+				}
+
+				// --function-context widens context to the whole scope
+				fn, inScope := innermostScope(scopes, lineno)
+				if opts.funcContext && inScope {
+					before = max(before, lineno-fn.start)
+					after = max(after, fn.end-lineno)
+				}
 				if before > 0 || after > 0 {
 					leading, trailing = contextLines(content, lineno, before, after)
 				}
@@ -613,6 +892,10 @@ func createMatches(searchResult SearchResult, fullText FullText, defaultBranches
 						lineno: lineno - len(leading) + i,
 						colno:  0,
 						text:   shrinkTabs(l),
+
+						function:  fn.name,
+						matchLine: lineno,
+						excerpt:   excerpt,
 					})
 				}
 
@@ -624,6 +907,10 @@ func createMatches(searchResult SearchResult, fullText FullText, defaultBranches
 					lineno: lineno,
 					colno:  idx - start,
 					text:   shrinkTabs(content[start : end+1]),
+
+					function:  fn.name,
+					matchLine: lineno,
+					excerpt:   excerpt,
 				})
 
 				for i, l := range trailing {
@@ -635,12 +922,37 @@ func createMatches(searchResult SearchResult, fullText FullText, defaultBranches
 						lineno: lineno + i + 1,
 						colno:  0,
 						text:   shrinkTabs(l),
+
+						function:  fn.name,
+						matchLine: lineno,
+						excerpt:   excerpt,
 					})
 				}
 			}
 		}
+		matches = append(matches[:fileStart], mergeContext(matches[fileStart:])...)
 	}
-	return matches
+	return matches, shown
+}
+
+// mergeContext of a file's matches so each line shows once, like 'git grep'
+// does when context overlaps. A line that matched wins over the same line as
+// context of another match.
+func mergeContext(matches []match) []match {
+	byLine := map[int]int{}
+	merged := []match{}
+	for _, m := range matches {
+		i, ok := byLine[m.lineno]
+		switch {
+		case !ok:
+			byLine[m.lineno] = len(merged)
+			merged = append(merged, m)
+		case m.lineno == m.matchLine && merged[i].lineno != merged[i].matchLine:
+			merged[i] = m
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].lineno < merged[j].lineno })
+	return merged
 }
 
 func max(a, b int) int {
@@ -650,6 +962,13 @@ func max(a, b int) int {
 	return b
 }
 
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // shrinkTabs into 2-width spaces
 //
 // The screen is cramped enough trying to fit repo context in without a monorepo
@@ -689,9 +1008,39 @@ func contextLines(content string, lineno, before, after int) (leading, trailing
 	return leading, trailing
 }
 
-func printFiles(r SearchResult) {
-	seen := map[string]struct{}{}
+// printListing when only files, full names, or repos were asked for, reporting
+// if it did
+func printListing(r SearchResult) bool {
+	return listing{}.print(r)
+}
+
+// listing remembers what's been printed so streamed pages don't repeat a repo
+// or path
+type listing map[string]struct{}
+
+func (l listing) print(r SearchResult) bool {
+	// Consistent order within a page, at least
+	keys := []FileKey{}
 	for key := range r {
+		keys = append(keys, key)
+	}
+	sort.Sort(FileKeys(keys))
+
+	switch {
+	case flags.onlyFiles:
+		printFiles(keys, l)
+	case flags.onlyFullNames:
+		printFullNames(keys, l)
+	case flags.onlyRepos:
+		printRepos(keys, l)
+	default:
+		return false
+	}
+	return true
+}
+
+func printFiles(keys []FileKey, seen listing) {
+	for _, key := range keys {
 		// We _could_ print this with ansiURL but that'd require us knowing the
 		// default branch of the repo. This requires another network round-trip
 		// which we only need to do for full output.
@@ -706,9 +1055,8 @@ func printFiles(r SearchResult) {
 	}
 }
 
-func printFullNames(r SearchResult) {
-	seen := map[string]struct{}{}
-	for key := range r {
+func printFullNames(keys []FileKey, seen listing) {
+	for _, key := range keys {
 		s := key.ColorString()
 		if _, ok := seen[s]; ok {
 			continue
@@ -718,9 +1066,8 @@ func printFullNames(r SearchResult) {
 	}
 }
 
-func printRepos(r SearchResult) {
-	seen := map[string]struct{}{}
-	for key := range r {
+func printRepos(keys []FileKey, seen listing) {
+	for _, key := range keys {
 		s := ansiURL(key.RepoString(), makeGithubSiteURL(key.RepoString()))
 		if _, ok := seen[s]; ok {
 			continue
@@ -731,7 +1078,7 @@ func printRepos(r SearchResult) {
 }
 
 // dumpData to stdout as functional code for easier test case making
-func dumpData(searchResult SearchResult, defaultBranches map[string]string, fullText FullText) {
+func dumpData(searchResult SearchResult, defaultBranches map[string]string, fullText FullText) error {
 	gen := strings.ReplaceAll(fmt.Sprintf(`
 package main
 
@@ -740,11 +1087,12 @@ var searchResults = %#v
 var defaultBranches = %#v
 
 var fullText = %#v
-		`, searchResult, defaultBranches, fullText), "main.", "")
+		`, searchResult, defaultBranches, FullText{Values: fullText.Values, Truncated: fullText.Truncated, Binary: fullText.Binary}), "main.", "")
 
 	formatted, err := format.Source([]byte(gen), format.Options{ExtraRules: true})
 	if err != nil {
-		fatalf("failed trying to format dumped data: %v", err)
+		return fmt.Errorf("failed trying to format dumped data: %w", err)
 	}
 	fmt.Println(string(formatted))
+	return nil
 }
@@ -0,0 +1,120 @@
+// Offline mode
+//
+// Every search that fetches file contents is kept under 'data_dir', keyed by
+// its query, as a snapshot of the results and the files they matched in.
+// '--offline' answers the same search from there with any output flags, for
+// flights and flaky VPNs. Results are only as fresh as the last time the
+// search ran online, and say so.
+//
+// A kept search holds the text of every file it matched in, so these are
+// pruned along with cached responses. (see cache.go)
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cachedSearch is a snapshot along with how far the search went
+type cachedSearch struct {
+	snapshot
+	Limit int `json:"limit"`
+}
+
+func cachedSearchPath(query string) (string, error) {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(query)))
+	return storePath("offline", hex.EncodeToString(sum[:]))
+}
+
+// covers is whether a search with limit went at least as far as one with
+// other, where zero is no limit at all
+func covers(limit, other int) bool {
+	return limit <= 0 || (other > 0 && limit >= other)
+}
+
+// recordSearch for --offline, leaving out files that couldn't be fetched
+//
+// What's kept is only replaced by a search that went at least as far, so a
+// quick look with a small --limit doesn't throw away a thorough one.
+func recordSearch(query string, searchResult SearchResult, defaultBranches map[string]string, fullText FullText) {
+	if flags.noCache {
+		return
+	}
+	pruneCaches()
+	path, err := cachedSearchPath(query)
+	if err != nil {
+		v("not keeping results for --offline: %v", err)
+		return
+	}
+	var prev cachedSearch
+	if err := loadJSON(path, &prev); err == nil && !covers(flags.limit, prev.Limit) {
+		v("not replacing results for --offline kept with --limit %d", prev.Limit)
+		return
+	}
+
+	fetched := SearchResult{}
+	for key, tms := range searchResult {
+		if _, ok := fullText.Values[key]; ok {
+			fetched[key] = tms
+		}
+	}
+	s := cachedSearch{snapshot: newSnapshot(query, fetched, defaultBranches, fullText), Limit: flags.limit}
+	if err := saveJSON(path, s); err != nil {
+		v("not keeping results for --offline: %v", err)
+	}
+}
+
+// executeOffline answers a search from the last time it ran online
+func executeOffline(query string) error {
+	switch {
+	case flags.count:
+		return errors.New("--count needs GitHub: it can't be used with --offline")
+	case flags.blame || flags.olderThan != "" || flags.newerThan != "":
+		return errors.New("blame needs GitHub: --blame, --older-than, and --newer-than can't be used with --offline")
+	case flags.groupBy != "":
+		return errors.New("--group-by needs GitHub: it can't be used with --offline")
+	}
+
+	path, err := cachedSearchPath(query)
+	if err != nil {
+		return err
+	}
+	var cached cachedSearch
+	if err := loadJSON(path, &cached); errors.Is(err, errNotStored) {
+		return fmt.Errorf("this search was never run online, so there's nothing to show offline: %s", strings.TrimSpace(query))
+	} else if err != nil {
+		return err
+	}
+	w("offline: showing results from %s (%s ago)", cached.Taken.Local().Format("2006-01-02 15:04"), time.Since(cached.Taken).Round(time.Minute))
+	if !covers(cached.Limit, flags.limit) && len(cached.Files) >= cached.Limit {
+		w("offline: only %d results were kept: run with --limit %d online to get more", len(cached.Files), flags.limit)
+	}
+
+	// --limit counts files like the search would. They're sorted, so it's
+	// always the same ones.
+	if flags.limit > 0 && len(cached.Files) > flags.limit {
+		cached.Files = cached.Files[:flags.limit]
+	}
+
+	if flags.saveSnapshot != "" {
+		if err := saveSnapshot(flags.saveSnapshot, cached.snapshot); err != nil {
+			return fmt.Errorf("couldn't save snapshot: %w", err)
+		}
+		v("Saved snapshot to %s", flags.saveSnapshot)
+	}
+
+	searchResult, defaultBranches, fullText := cached.unpack()
+	if printListing(searchResult) {
+		return matchStatus(len(searchResult))
+	}
+	if flags.dumpData {
+		return dumpData(searchResult, defaultBranches, fullText)
+	}
+	matches := createMatches(searchResult, fullText, defaultBranches)
+	printMatches(matches)
+	return matchStatus(len(matches))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestOffline(t *testing.T) {
+	viper.Set("data_dir", t.TempDir())
+	defer viper.Set("data_dir", nil)
+	flags.greppable = true
+	defer func() { flags.greppable = false }()
+
+	key := FileKey{Owner: "coxley", Name: "codesearch", Path: "main.go"}
+	failed := FileKey{Owner: "coxley", Name: "codesearch", Path: "gone.go"}
+	searchResult := SearchResult{
+		key:    {{Fragment: "func main() {", Indices: [][2]int{{5, 9}}}},
+		failed: {{Fragment: "func gone() {", Indices: [][2]int{{5, 9}}}},
+	}
+	fullText := FullText{
+		Values:    map[FileKey]string{key: "package main\n\nfunc main() {\n}\n"},
+		Truncated: map[FileKey]bool{},
+		Failed:    map[FileKey]error{failed: errors.New("timeout")},
+	}
+	recordSearch("main ", searchResult, map[string]string{"coxley/codesearch": "main"}, fullText)
+
+	var err error
+	out := withStdout(t, func() { err = executeOffline("main") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "coxley/codesearch:main.go:3: func main() {\n"; out != want {
+		t.Errorf("expected %q, got: %q", want, out)
+	}
+
+	if err := executeOffline("other"); err == nil || !strings.Contains(err.Error(), "never run online") {
+		t.Errorf("expected an error for a search that wasn't cached, got: %v", err)
+	}
+
+	flags.blame = true
+	defer func() { flags.blame = false }()
+	if err := executeOffline("main"); err == nil {
+		t.Error("expected --blame to need GitHub")
+	}
+}
+
+func TestRecordSearchKeepsWiderRuns(t *testing.T) {
+	viper.Set("data_dir", t.TempDir())
+	defer viper.Set("data_dir", nil)
+	flags.greppable = true
+	defer func() { flags.greppable, flags.limit = false, 30 }()
+
+	branches := map[string]string{"coxley/codesearch": "main"}
+	record := func(limit int, path string) {
+		key := FileKey{Owner: "coxley", Name: "codesearch", Path: path}
+		flags.limit = limit
+		recordSearch("main", SearchResult{key: {{Fragment: "main", Indices: [][2]int{{0, 4}}}}}, branches, FullText{
+			Values:    map[FileKey]string{key: "main\n"},
+			Truncated: map[FileKey]bool{},
+		})
+	}
+	offline := func() string {
+		flags.limit = 30
+		return withStdout(t, func() {
+			if err := executeOffline("main"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	record(100, "wide.go")
+	record(10, "narrow.go")
+	if out := offline(); !strings.Contains(out, "wide.go") {
+		t.Errorf("expected a narrower run to keep the wider one, got: %q", out)
+	}
+	record(0, "all.go")
+	if out := offline(); !strings.Contains(out, "all.go") {
+		t.Errorf("expected an unlimited run to replace it, got: %q", out)
+	}
+	record(500, "again.go")
+	if out := offline(); !strings.Contains(out, "all.go") {
+		t.Errorf("expected nothing to replace an unlimited run, got: %q", out)
+	}
+}
@@ -0,0 +1,45 @@
+// Tabular output for the reporting subcommands
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// writeRows to stdout as an aligned table or CSV
+//
+// Colors and links should only go in the last column of a table. Their escape
+// sequences throw off alignment anywhere else.
+func writeRows(format string, header []string, rows [][]string) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, row := range append([][]string{header}, rows...) {
+			for i, col := range row {
+				if i > 0 {
+					fmt.Fprint(tw, "\t")
+				}
+				fmt.Fprint(tw, col)
+			}
+			fmt.Fprintln(tw)
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(os.Stdout)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		return cw.WriteAll(rows)
+	}
+	return fmt.Errorf("unknown format: %s", format)
+}
+
+// writeJSON to stdout, indented for humans but still easy on jq
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
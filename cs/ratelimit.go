@@ -0,0 +1,229 @@
+// Rate limiting
+//
+// GitHub limits each resource separately: code search is 30 requests a minute,
+// GraphQL and REST have hourly budgets, and secondary limits kick in when we
+// go too fast. Every request goes through one scheduler that remembers what's
+// left of each, waits out resets instead of failing, and honours Retry-After.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// How many times a rate-limited request is retried before giving up
+const maxRateLimitRetries = 3
+
+// Secondary limits don't always say how long to wait
+const defaultRetryAfter = time.Minute
+
+// rateLimitError is returned instead of waiting with --no-wait
+type rateLimitError struct {
+	resource string
+	reset    time.Time
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf(
+		"GitHub's %s rate limit is exhausted until %s (%s from now)",
+		e.resource, e.reset.Local().Format("15:04:05"), time.Until(e.reset).Round(time.Second),
+	)
+}
+
+type quota struct {
+	remaining int
+	reset     time.Time
+}
+
+// rateLimiter tracks quota per resource and makes callers wait for it
+type rateLimiter struct {
+	mu     sync.Mutex
+	quotas map[string]quota
+
+	// Swapped out by tests
+	now   func() time.Time
+	sleep func(ctx context.Context, resource string, until time.Time) error
+}
+
+var scheduler = newRateLimiter()
+
+func newRateLimiter() *rateLimiter {
+	l := &rateLimiter{quotas: map[string]quota{}, now: time.Now}
+	l.sleep = l.countdown
+	return l
+}
+
+// resourceOf a request, until GitHub tells us with X-RateLimit-Resource
+func resourceOf(req *http.Request) string {
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/graphql"):
+		return "graphql"
+	case strings.Contains(req.URL.Path, "/search/"):
+		return "search"
+	default:
+		return "core"
+	}
+}
+
+// reserve one request of a resource, waiting for its reset if there's none
+// left
+func (l *rateLimiter) reserve(ctx context.Context, resource string) error {
+	for {
+		l.mu.Lock()
+		q, ok := l.quotas[resource]
+		now := l.now()
+		if !ok || q.remaining > 0 || !now.Before(q.reset) {
+			if ok && q.remaining > 0 {
+				q.remaining--
+				l.quotas[resource] = q
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if err := l.wait(ctx, resource, q.reset); err != nil {
+			return err
+		}
+	}
+}
+
+// waitUntil a resource resets, for limits found outside of the transport
+func (l *rateLimiter) waitUntil(ctx context.Context, resource string, reset time.Time) error {
+	l.mu.Lock()
+	l.quotas[resource] = quota{remaining: 0, reset: reset}
+	l.mu.Unlock()
+	return l.reserve(ctx, resource)
+}
+
+func (l *rateLimiter) wait(ctx context.Context, resource string, until time.Time) error {
+	if flags.noWait {
+		return &rateLimitError{resource: resource, reset: until}
+	}
+	return l.sleep(ctx, resource, until)
+}
+
+// update quota from response headers
+func (l *rateLimiter) update(resource string, h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.quotas[resource] = quota{remaining: remaining, reset: time.Unix(reset, 0)}
+	v("Rate limit (%s): %d remaining until %s", resource, remaining, time.Unix(reset, 0).Format("15:04:05"))
+}
+
+// retryAt reports when a rate-limited response can be retried
+//
+// Primary limits come back as 403 or 429 with nothing remaining. Secondary
+// limits are a 403 or 429 with Retry-After, or sometimes nothing at all.
+func (l *rateLimiter) retryAt(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+	now := l.now()
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return now.Add(time.Duration(secs) * time.Second), true
+		}
+		if t, err := http.ParseTime(s); err == nil {
+			return t, true
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Unix(reset, 0), true
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return now.Add(defaultRetryAfter), true
+	}
+	// A plain 403 is a permissions problem, not ours to retry
+	return time.Time{}, false
+}
+
+// countdown on stderr until a reset, or just a note when it isn't a terminal
+func (l *rateLimiter) countdown(ctx context.Context, resource string, until time.Time) error {
+	tty := isatty.IsTerminal(os.Stderr.Fd())
+	if !tty {
+		w("rate limited by GitHub (%s): waiting %s", resource, time.Until(until).Round(time.Second))
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		left := time.Until(until).Round(time.Second)
+		if left <= 0 {
+			break
+		}
+		if tty {
+			fmt.Fprintf(os.Stderr, "\r\033[K%s", color.YellowString("rate limited by GitHub (%s): waiting %s (--no-wait to fail instead)", resource, left))
+		}
+		select {
+		case <-ctx.Done():
+			if tty {
+				fmt.Fprint(os.Stderr, "\r\033[K")
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	if tty {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	return nil
+}
+
+// rateLimitTransport puts every request through the scheduler
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := resourceOf(req)
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.reserve(req.Context(), resource); err != nil {
+			return nil, err
+		}
+
+		send, err := replayRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(send)
+		if err != nil {
+			return nil, err
+		}
+		t.limiter.update(resource, resp.Header)
+
+		until, limited := t.limiter.retryAt(resp)
+		if !limited || attempt >= maxRateLimitRetries {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		v("Rate limited (%s): retrying at %s", resource, until.Format("15:04:05"))
+		if err := t.limiter.wait(req.Context(), resource, until); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLimiter records waits instead of sleeping
+func fakeLimiter(now time.Time) (*rateLimiter, *[]time.Time) {
+	waits := []time.Time{}
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, resource string, until time.Time) error {
+		waits = append(waits, until)
+		// Time passes while we wait
+		now = until
+		return nil
+	}
+	return l, &waits
+}
+
+func TestRateLimitTransportRetries(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	var calls int
+	bodies := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		switch calls {
+		case 1:
+			// Secondary limit
+			rw.Header().Set("Retry-After", "30")
+			rw.WriteHeader(http.StatusForbidden)
+		case 2:
+			// Primary limit
+			rw.Header().Set("X-RateLimit-Remaining", "0")
+			rw.Header().Set("X-RateLimit-Reset", fmt.Sprint(reset.Unix()))
+			rw.WriteHeader(http.StatusTooManyRequests)
+		default:
+			rw.Header().Set("X-RateLimit-Remaining", "41")
+			rw.Header().Set("X-RateLimit-Reset", fmt.Sprint(reset.Unix()))
+			fmt.Fprint(rw, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	l, waits := fakeLimiter(start)
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport, limiter: l}}
+	resp, err := client.Post(srv.URL+"/graphql", "application/json", strings.NewReader(`{"query": "{}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("expected success on the third call, got: %d after %d calls", resp.StatusCode, calls)
+	}
+	for _, b := range bodies {
+		if b != `{"query": "{}"}` {
+			t.Errorf("expected the body to be replayed on retries, got: %q", bodies)
+			break
+		}
+	}
+	if len(*waits) != 2 || !(*waits)[0].Equal(start.Add(30*time.Second)) || !(*waits)[1].Equal(reset) {
+		t.Errorf("expected to wait for Retry-After then the reset, got: %v", *waits)
+	}
+	if q := l.quotas["graphql"]; q.remaining != 41 {
+		t.Errorf("expected quota to be tracked from headers, got: %+v", q)
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	l, waits := fakeLimiter(now)
+	ctx := context.Background()
+
+	// Nothing known yet
+	if err := l.reserve(ctx, "search"); err != nil || len(*waits) != 0 {
+		t.Fatalf("expected no wait without quota info, got: %v %v", err, *waits)
+	}
+
+	l.quotas["search"] = quota{remaining: 1, reset: now.Add(time.Minute)}
+	if err := l.reserve(ctx, "search"); err != nil || len(*waits) != 0 {
+		t.Fatalf("expected the last request to go through, got: %v %v", err, *waits)
+	}
+	if err := l.reserve(ctx, "search"); err != nil || len(*waits) != 1 || !(*waits)[0].Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected to wait for the reset, got: %v %v", err, *waits)
+	}
+
+	// Other resources aren't affected
+	l.quotas["search"] = quota{remaining: 0, reset: now.Add(time.Hour)}
+	if err := l.reserve(ctx, "core"); err != nil || len(*waits) != 1 {
+		t.Fatalf("expected core not to wait on search, got: %v %v", err, *waits)
+	}
+
+	flags.noWait = true
+	defer func() { flags.noWait = false }()
+	var rle *rateLimitError
+	if err := l.reserve(ctx, "search"); !errors.As(err, &rle) || rle.resource != "search" {
+		t.Errorf("expected a rateLimitError with --no-wait, got: %v", err)
+	}
+}
+
+func TestRetryAt(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	l, _ := fakeLimiter(now)
+	td := []struct {
+		status   int
+		headers  map[string]string
+		expected time.Time
+		limited  bool
+	}{
+		{200, nil, time.Time{}, false},
+		{403, nil, time.Time{}, false},
+		{404, map[string]string{"Retry-After": "5"}, time.Time{}, false},
+		{403, map[string]string{"Retry-After": "5"}, now.Add(5 * time.Second), true},
+		{403, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1664626800"}, time.Unix(1664626800, 0), true},
+		{429, nil, now.Add(defaultRetryAfter), true},
+	}
+	for _, tc := range td {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+		for k, v := range tc.headers {
+			resp.Header.Set(k, v)
+		}
+		got, limited := l.retryAt(resp)
+		if limited != tc.limited || !got.Equal(tc.expected) {
+			t.Errorf("%d %v: expected: %v %v, got: %v %v", tc.status, tc.headers, tc.expected, tc.limited, got, limited)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Go-aware reference search
+//
+// Text search can't tell 'viper.Get(' apart from a local variable that happens
+// to be called 'viper'. Here we search for the import path, parse every Go
+// file that comes back, and only keep selector expressions that resolve to the
+// imported package.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var refsCmd = &cobra.Command{
+	Use:   "refs importpath.Symbol",
+	Short: "Find references to an exported Go symbol, resolving import aliases",
+	Long: `
+Find references to an exported Go symbol, resolving import aliases
+
+Searches Go files that mention the import path, parses them, and shows only
+selector expressions referring to the symbol: calls, type usages, method values.
+Unrelated identifiers with the same name are left out.
+
+	cs refs github.com/spf13/viper.WriteConfig
+	cs refs net/http.DefaultClient
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeRefs,
+}
+
+func init() {
+	rootCmd.AddCommand(refsCmd)
+}
+
+func executeRefs(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+
+	importPath, symbol, err := splitSymbol(args[0])
+	if err != nil {
+		return err
+	}
+
+	terms := []string{strconv.Quote(importPath), symbol}
+	if flags.lang == "" {
+		terms = append(terms, "language:go")
+	}
+	query := makeQuery(terms)
+	if flags.showQuery {
+		fmt.Println(query)
+		return nil
+	}
+	v("Query: %s", query)
+
+	res, err := performSearch(ctx, query, flags.limit)
+	if err != nil {
+		return err
+	}
+	searchResult := coerceResults(res)
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
+	}
+
+	// References are only useful knowing where they come from
+	flags.showFunction = true
+	refs := goRefsResult(fullText, importPath, symbol)
+	printMatches(createMatches(refs, fullText, defaultBranches))
+	return nil
+}
+
+// splitSymbol breaks "github.com/spf13/viper.WriteConfig" into the import path
+// and exported symbol
+func splitSymbol(s string) (string, string, error) {
+	dot := strings.LastIndex(s, ".")
+	if dot == -1 || dot < strings.LastIndex(s, "/") {
+		return "", "", fmt.Errorf("expected importpath.Symbol, got: %s", s)
+	}
+
+	importPath, symbol := s[:dot], s[dot+1:]
+	if importPath == "" || !gotoken.IsIdentifier(symbol) || !gotoken.IsExported(symbol) {
+		return "", "", fmt.Errorf("expected an exported symbol after the import path, got: %s", symbol)
+	}
+	return importPath, symbol, nil
+}
+
+// goRefsResult rewrites fetched files into a SearchResult where each fragment
+// is the whole file, and indices point at resolved references
+//
+// This lets createMatches do highlighting, context, and scopes for us like any
+// other search.
+func goRefsResult(fullText FullText, importPath, symbol string) SearchResult {
+	result := SearchResult{}
+	for key, content := range fullText.Values {
+		if !strings.HasSuffix(key.Path, ".go") {
+			continue
+		}
+
+		fset := gotoken.NewFileSet()
+		f, err := parser.ParseFile(fset, key.Path, content, parser.ParseComments)
+		if err != nil {
+			v("couldn't parse %s: %v", key.String(), err)
+			continue
+		}
+
+		indices := findGoRefs(fset, f, importPath, symbol)
+		if len(indices) == 0 {
+			continue
+		}
+		result[key] = []TextMatch{{Fragment: content, Indices: indices}}
+	}
+	return result
+}
+
+// findGoRefs returns byte offsets of every 'pkg.Symbol' selector where 'pkg'
+// refers to the import
+func findGoRefs(fset *gotoken.FileSet, f *ast.File, importPath, symbol string) [][2]int {
+	return goSelectors(fset, f, importPath)[symbol]
+}
+
+// goSelectors returns byte offsets of every 'pkg.Name' selector where 'pkg'
+// refers to the import, keyed by Name
+//
+// Identifiers shadowing the import (a local named 'http', for instance) are
+// resolved by the parser and skipped.
+func goSelectors(fset *gotoken.FileSet, f *ast.File, importPath string) map[string][][2]int {
+	name, ok := goImportName(f, importPath)
+	if !ok {
+		return nil
+	}
+
+	selectors := map[string][][2]int{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Name != name || x.Obj != nil {
+			return true
+		}
+		selectors[sel.Sel.Name] = append(selectors[sel.Sel.Name], [2]int{
+			fset.Position(sel.Pos()).Offset,
+			fset.Position(sel.End()).Offset,
+		})
+		return true
+	})
+	return selectors
+}
+
+// goImportName is what the file calls the import, accounting for aliases
+//
+// Blank and dot imports have no name to select from so they're reported as
+// not found.
+func goImportName(f *ast.File, importPath string) (string, bool) {
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || p != importPath {
+			continue
+		}
+		if imp.Name == nil {
+			return guessPkgName(importPath), true
+		}
+		if imp.Name.Name == "_" || imp.Name.Name == "." {
+			v("skipping %s import of %s", imp.Name.Name, importPath)
+			return "", false
+		}
+		return imp.Name.Name, true
+	}
+	return "", false
+}
+
+// guessPkgName from an import path the same way goimports does
+//
+// Without fetching the package itself we can't know its real name, but this
+// covers major versions (/v2), gopkg.in (yaml.v3), and go- prefixes.
+func guessPkgName(importPath string) string {
+	base := path.Base(importPath)
+	if isMajorVersion(base) {
+		if dir := path.Dir(importPath); dir != "." {
+			base = path.Base(dir)
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
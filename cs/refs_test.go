@@ -0,0 +1,85 @@
+package main
+
+import (
+	"go/parser"
+	gotoken "go/token"
+	"testing"
+)
+
+func TestGuessPkgName(t *testing.T) {
+	td := map[string]string{
+		"fmt":                                    "fmt",
+		"net/http":                               "http",
+		"github.com/google/go-github/v47/github": "github",
+		"github.com/pelletier/go-toml/v2":        "toml",
+		"gopkg.in/yaml.v3":                       "yaml",
+		"mvdan.cc/gofumpt/format":                "format",
+	}
+	for importPath, expected := range td {
+		if got := guessPkgName(importPath); got != expected {
+			t.Errorf("%s: expected: %s, got: %s", importPath, expected, got)
+		}
+	}
+}
+
+func TestSplitSymbol(t *testing.T) {
+	importPath, symbol, err := splitSymbol("github.com/spf13/viper.WriteConfig")
+	if err != nil || importPath != "github.com/spf13/viper" || symbol != "WriteConfig" {
+		t.Errorf("unexpected split: %q %q %v", importPath, symbol, err)
+	}
+
+	for _, bad := range []string{"github.com/spf13/viper", "fmt.println", "fmt."} {
+		if _, _, err := splitSymbol(bad); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestFindGoRefs(t *testing.T) {
+	src := `package foo
+
+import (
+	v "github.com/spf13/viper"
+	"net/http"
+)
+
+type Config struct{}
+
+func (c *Config) Load() {
+	v.WriteConfig()
+	f := v.WriteConfig
+	f()
+}
+
+func shadowed() {
+	v := struct{ WriteConfig func() }{}
+	v.WriteConfig()
+	viper.WriteConfig()
+	http.Get("")
+}
+`
+	fset := gotoken.NewFileSet()
+	f, err := parser.ParseFile(fset, "foo.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indices := findGoRefs(fset, f, "github.com/spf13/viper", "WriteConfig")
+	if len(indices) != 2 {
+		t.Fatalf("expected 2 references, got: %v", indices)
+	}
+	for _, idx := range indices {
+		if got := src[idx[0]:idx[1]]; got != "v.WriteConfig" {
+			t.Errorf("expected reference to be v.WriteConfig, got: %s", got)
+		}
+	}
+
+	scopes := goScopes(fset, f)
+	for _, idx := range indices {
+		lineno := fset.Position(fset.File(f.Pos()).Pos(idx[0])).Line
+		s, ok := innermostScope(scopes, lineno)
+		if !ok || s.name != "(*Config).Load" {
+			t.Errorf("expected line %d to be in (*Config).Load, got: %+v", lineno, s)
+		}
+	}
+}
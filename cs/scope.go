@@ -0,0 +1,280 @@
+// Scopes are the named regions of a file that a match can live in: functions,
+// methods, and types. They let us show *where* a line is, not just what it says.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// scope is a named region of a file
+type scope struct {
+	name string
+	// 1-indexed and inclusive
+	start int
+	end   int
+}
+
+// goScopes for top-level functions, methods, and type declarations
+func goScopes(fset *gotoken.FileSet, f *ast.File) []scope {
+	scopes := []scope{}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			scopes = append(scopes, scope{
+				name:  goFuncName(d),
+				start: fset.Position(d.Pos()).Line,
+				end:   fset.Position(d.End()).Line,
+			})
+		case *ast.GenDecl:
+			if d.Tok != gotoken.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				// A lone declaration starts at the 'type' keyword while grouped
+				// ones start at their own name.
+				node := ast.Node(ts)
+				if !d.Lparen.IsValid() {
+					node = d
+				}
+				scopes = append(scopes, scope{
+					name:  ts.Name.Name,
+					start: fset.Position(node.Pos()).Line,
+					end:   fset.Position(node.End()).Line,
+				})
+			}
+		}
+	}
+	return scopes
+}
+
+// goFuncName renders methods like pprof does: (*Client).Do
+func goFuncName(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return d.Name.Name
+	}
+
+	recv := d.Recv.List[0].Type
+	var star string
+	if s, ok := recv.(*ast.StarExpr); ok {
+		star = "*"
+		recv = s.X
+	}
+
+	// Drop type parameters from generic receivers: List[T] -> List
+	switch r := recv.(type) {
+	case *ast.IndexExpr:
+		recv = r.X
+	case *ast.IndexListExpr:
+		recv = r.X
+	}
+
+	var name string
+	if ident, ok := recv.(*ast.Ident); ok {
+		name = ident.Name
+	}
+
+	if star != "" {
+		return fmt.Sprintf("(%s%s).%s", star, name, d.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s", name, d.Name.Name)
+}
+
+// innermostScope containing the line, if any
+//
+// Scopes can nest (classes and their methods), so the narrowest one wins.
+func innermostScope(scopes []scope, lineno int) (scope, bool) {
+	var found scope
+	var ok bool
+	for _, s := range scopes {
+		if lineno < s.start || lineno > s.end {
+			continue
+		}
+		if !ok || s.end-s.start < found.end-found.start {
+			found = s
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// fileScopes picks a strategy based on the file extension
+//
+// Go gets a real parser. Everything else is heuristics: indentation for Python
+// and Ruby, brace matching for the C family. They're wrong sometimes, but
+// cheap and right often enough to orient yourself.
+func fileScopes(filename, content string) []scope {
+	switch ext := path.Ext(filename); {
+	case ext == ".go":
+		fset := gotoken.NewFileSet()
+		f, err := parser.ParseFile(fset, filename, content, parser.SkipObjectResolution)
+		if err == nil {
+			return goScopes(fset, f)
+		}
+		v("couldn't parse %s, falling back to heuristics: %v", filename, err)
+		return qualifyScopes(braceScopes(content))
+	case indentExts[ext]:
+		return qualifyScopes(indentScopes(content))
+	case braceExts[ext]:
+		return qualifyScopes(braceScopes(content))
+	}
+	return nil
+}
+
+var indentExts = map[string]bool{
+	".py": true, ".pyi": true, ".rb": true, ".rake": true,
+}
+
+var braceExts = map[string]bool{
+	".js": true, ".jsx": true, ".mjs": true, ".cjs": true, ".ts": true, ".tsx": true,
+	".java": true, ".kt": true, ".kts": true, ".scala": true, ".groovy": true,
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".cxx": true, ".hh": true, ".hpp": true,
+	".m": true, ".mm": true, ".cs": true, ".rs": true, ".php": true, ".swift": true,
+	".dart": true, ".proto": true,
+}
+
+var (
+	indentDefRe = regexp.MustCompile(`^(\s*)(?:async\s+def|def|class|module)\s+(?:self\.)?([A-Za-z_][\w?!]*)`)
+
+	// class Foo, impl Bar, message Baz, ...
+	braceTypeRe = regexp.MustCompile(`\b(?:class|interface|struct|enum|trait|impl|object|namespace|message|service)\s+([A-Za-z_$][\w$]*)`)
+	// function foo, fn foo, func foo, fun foo, def foo
+	braceFuncRe = regexp.MustCompile(`\b(?:function|fn|func|fun|def)\s*\*?\s*([A-Za-z_$][\w$]*)`)
+	// const foo = (...) => or const foo = function
+	braceAssignRe = regexp.MustCompile(`\b(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*=>|[A-Za-z_$][\w$]*\s*=>)`)
+	// public static int foo( -- at least one word ahead of the name keeps plain calls out
+	braceMethodRe = regexp.MustCompile(`^\s*(?:[\w<>\[\],.*&:~]+\s+)+[*&]?([A-Za-z_~]\w*)\s*\(`)
+	// render(props): string { -- JS/TS class methods are just a name, so the brace must follow
+	braceShortMethodRe = regexp.MustCompile(`^\s*([A-Za-z_$][\w$]*)\s*\([^;]*\)\s*(?::[^{;]+)?\{\s*$`)
+)
+
+// Words that look like a method name to braceMethodRe but never are
+var notMethods = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true,
+	"return": true, "else": true, "new": true, "throw": true, "sizeof": true,
+	"do": true, "case": true, "typeof": true, "await": true, "delete": true,
+}
+
+// indentScopes ends a definition at the last line indented deeper than it
+//
+// Ruby's trailing 'end' sits at the same depth as its 'def', so it's included
+// when it's the very next thing.
+func indentScopes(content string) []scope {
+	lines := strings.Split(content, "\n")
+	scopes := []scope{}
+	for i, l := range lines {
+		m := indentDefRe.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		depth := len(m[1])
+		last := i
+		for j := i + 1; j < len(lines); j++ {
+			trimmed := strings.TrimSpace(lines[j])
+			if trimmed == "" {
+				continue
+			}
+			if indentation(lines[j]) > depth {
+				last = j
+				continue
+			}
+			if trimmed == "end" {
+				last = j
+			}
+			break
+		}
+		scopes = append(scopes, scope{name: m[2], start: i + 1, end: last + 1})
+	}
+	return scopes
+}
+
+func indentation(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " \t"))
+}
+
+// braceScopes ends a definition where its first opening brace is closed
+//
+// The brace has to show up within a couple lines of the name and before any
+// semicolon, otherwise it was a declaration or call rather than a definition.
+// Braces inside strings and comments aren't special-cased.
+func braceScopes(content string) []scope {
+	lines := strings.Split(content, "\n")
+	scopes := []scope{}
+	for i, l := range lines {
+		name := braceScopeName(l)
+		if name == "" {
+			continue
+		}
+
+		var depth int
+		var opened bool
+		end := -1
+	scan:
+		for j := i; j < len(lines); j++ {
+			for _, c := range lines[j] {
+				switch {
+				case c == ';' && !opened:
+					break scan
+				case c == '{':
+					depth++
+					opened = true
+				case c == '}' && opened:
+					depth--
+				}
+				if opened && depth == 0 {
+					end = j
+					break scan
+				}
+			}
+			if !opened && j-i >= 2 {
+				break
+			}
+		}
+		if end == -1 {
+			continue
+		}
+		scopes = append(scopes, scope{name: name, start: i + 1, end: end + 1})
+	}
+	return scopes
+}
+
+func braceScopeName(line string) string {
+	for _, re := range []*regexp.Regexp{braceTypeRe, braceFuncRe, braceAssignRe} {
+		if m := re.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	for _, re := range []*regexp.Regexp{braceMethodRe, braceShortMethodRe} {
+		if m := re.FindStringSubmatch(line); m != nil && !notMethods[m[1]] {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// qualifyScopes prefixes nested scopes with their parents: Foo.render
+func qualifyScopes(scopes []scope) []scope {
+	sort.SliceStable(scopes, func(i, j int) bool {
+		return scopes[i].start < scopes[j].start
+	})
+
+	qualified := make([]scope, len(scopes))
+	copy(qualified, scopes)
+	for i, s := range scopes {
+		// Scopes are sorted so the closest enclosing one is the latest match
+		for j := i - 1; j >= 0; j-- {
+			if scopes[j].start <= s.start && scopes[j].end >= s.end {
+				qualified[i].name = qualified[j].name + "." + s.name
+				break
+			}
+		}
+	}
+	return qualified
+}
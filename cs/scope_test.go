@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestFileScopes(t *testing.T) {
+	type data struct {
+		filename string
+		content  string
+		lineno   int
+		expected string
+	}
+
+	py := "import os\n\nclass Foo:\n    def bar(self,\n            x):\n        return x\n\n    def baz(self):\n        pass\n\nprint('hi')\n"
+	rb := "class Foo\n  def self.bar\n    1\n  end\nend\n"
+	ts := "export class Foo {\n  render(): string {\n    return 'x';\n  }\n}\n\nconst handler = async (req) => {\n  go();\n};\n"
+	java := "public class Foo\n{\n  public static int bar(int x)\n  {\n    if (x) {\n      return baz(x);\n    }\n  }\n}\n"
+
+	td := []data{
+		{"foo.py", py, 1, ""},
+		{"foo.py", py, 3, "Foo"},
+		{"foo.py", py, 5, "Foo.bar"},
+		{"foo.py", py, 6, "Foo.bar"},
+		{"foo.py", py, 7, "Foo"},
+		{"foo.py", py, 9, "Foo.baz"},
+		{"foo.py", py, 11, ""},
+
+		{"foo.rb", rb, 3, "Foo.bar"},
+		{"foo.rb", rb, 4, "Foo.bar"},
+		{"foo.rb", rb, 5, "Foo"},
+
+		{"foo.ts", ts, 3, "Foo.render"},
+		{"foo.ts", ts, 5, "Foo"},
+		{"foo.ts", ts, 6, ""},
+		{"foo.ts", ts, 8, "handler"},
+
+		{"Foo.java", java, 6, "Foo.bar"},
+		{"Foo.java", java, 9, "Foo"},
+
+		{"foo.txt", py, 6, ""},
+	}
+	for _, test := range td {
+		s, _ := innermostScope(fileScopes(test.filename, test.content), test.lineno)
+		if s.name != test.expected {
+			t.Errorf("%s:%d: expected: %q, got: %q", test.filename, test.lineno, test.expected, s.name)
+		}
+	}
+}
+
+func TestFunctionContextMerges(t *testing.T) {
+	flags.funcContext = true
+	defer func() { flags.funcContext = false }()
+
+	key := FileKey{Owner: "coxley", Name: "codesearch", Path: "main.go"}
+	content := "package main\n\nfunc main() {\n\tfoo()\n\tbar()\n\tfoo()\n}\n"
+	searchResult := SearchResult{key: {
+		{Fragment: "\tfoo()\n\tbar()", Indices: [][2]int{{1, 4}}},
+		{Fragment: "\tbar()\n\tfoo()\n}", Indices: [][2]int{{8, 11}}},
+	}}
+	fullText := FullText{Values: map[FileKey]string{key: content}}
+
+	matches := createMatches(searchResult, fullText, map[string]string{"coxley/codesearch": "main"})
+	linenos := []int{}
+	var hits int
+	for _, m := range matches {
+		linenos = append(linenos, m.lineno)
+		if m.lineno == m.matchLine {
+			hits++
+		}
+	}
+	if want := []int{3, 4, 5, 6, 7}; !slices.Equal(linenos, want) || hits != 2 {
+		t.Errorf("expected the function once with both matches, got lines %v (%d matches)", linenos, hits)
+	}
+}
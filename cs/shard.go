@@ -0,0 +1,318 @@
+// Searching past GitHub's cap
+//
+// Code search stops at 1000 results per query, however many there are. When
+// a search has more and --limit wants them, it's split into shards that each
+// stay under the cap: ranges of file size, single repos from the org's
+// listing, or file extensions. Shards run one after another through the same
+// rate limit budget as everything else, files they share are only reported
+// once, and anything the shards couldn't reach is reported at the end.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v47/github"
+)
+
+// searchCap is how many results GitHub returns for one query
+const searchCap = 1000
+
+// GitHub doesn't index files larger than this
+const maxIndexedSize = 384 << 10
+
+// Extensions that get their own shard with --shard-by ext. The rest share one
+// shard excluding all of them, and search queries can't be very long.
+var shardExtensions = []string{"go", "py", "js", "ts", "java", "rb", "c", "cpp", "rs", "md"}
+
+var queryOwnerRe = regexp.MustCompile(`(?:^|\s)(?:org|user):(\S+)`)
+
+// shard is a slice of a search, as extra qualifiers
+type shard struct {
+	minSize, maxSize int
+	repo             string
+	ext              string
+	// Every extension without a shard of its own
+	otherExts bool
+}
+
+func rootShard() shard {
+	return shard{minSize: 0, maxSize: maxIndexedSize}
+}
+
+func (s shard) qualifiers() string {
+	q := []string{}
+	if s.minSize != 0 || s.maxSize != maxIndexedSize {
+		q = append(q, fmt.Sprintf("size:%d..%d", s.minSize, s.maxSize))
+	}
+	if s.repo != "" {
+		q = append(q, "repo:"+s.repo)
+	}
+	if s.ext != "" {
+		q = append(q, "extension:"+s.ext)
+	}
+	if s.otherExts {
+		for _, ext := range shardExtensions {
+			q = append(q, "-extension:"+ext)
+		}
+	}
+	return strings.Join(q, " ")
+}
+
+// splitter divides a shard into smaller ones, or returns none when it can't
+type splitter func(ctx context.Context, s shard) ([]shard, error)
+
+// searcher pages through a search, sharding it when GitHub's cap gets in the
+// way
+type searcher struct {
+	client    *github.Client
+	query     string
+	remaining int
+	fn        func([]*github.CodeResult) error
+	splitters []splitter
+
+	seen map[FileKey]struct{}
+	// For the completeness report
+	shards int
+	found  int
+	// What GitHub says the whole search has
+	total       int
+	unreachable int
+	timedOut    bool
+
+	repos []string
+}
+
+func newSearcher(client *github.Client, query string, limit int, fn func([]*github.CodeResult) error) (*searcher, error) {
+	s := &searcher{
+		client:    client,
+		query:     strings.TrimSpace(query),
+		remaining: limit,
+		fn:        fn,
+		seen:      map[FileKey]struct{}{},
+	}
+	switch flags.shardBy {
+	case "", "size":
+		s.splitters = []splitter{s.splitBySize, s.splitByRepo}
+	case "repo":
+		s.splitters = []splitter{s.splitByRepo, s.splitBySize}
+	case "ext":
+		s.splitters = []splitter{s.splitByExt, s.splitBySize, s.splitByRepo}
+	default:
+		return nil, fmt.Errorf("unsupported --shard-by: %s", flags.shardBy)
+	}
+	return s, nil
+}
+
+func (s *searcher) run(ctx context.Context) error {
+	queue := []shard{rootShard()}
+	for len(queue) > 0 && s.remaining > 0 {
+		sh := queue[0]
+		queue = queue[1:]
+		split, err := s.search(ctx, sh)
+		if err != nil {
+			return err
+		}
+		// Depth first, so results keep coming while shards are found
+		queue = append(split, queue...)
+	}
+	s.report()
+	return nil
+}
+
+// search one shard, returning smaller ones instead when it's over the cap
+func (s *searcher) search(ctx context.Context, sh shard) ([]shard, error) {
+	s.shards++
+	query := strings.TrimSpace(s.query + " " + sh.qualifiers())
+	opts := &github.SearchOptions{TextMatch: true}
+	opts.Page = 1
+	// GitHub only allows 100 results. We'll make things right with paging.
+	opts.PerPage = min(s.remaining, 100)
+
+	var soFar int
+	for s.remaining > 0 {
+		v("Page: %d %s", opts.Page, sh.qualifiers())
+		res, _, err := s.client.Search.Code(ctx, query, opts)
+		// go-github refuses requests it already knows will be limited, before
+		// they reach the scheduler
+		var rle *github.RateLimitError
+		if errors.As(err, &rle) {
+			if err := scheduler.waitUntil(ctx, "search", rle.Rate.Reset.Time); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, githubError(err)
+		}
+		total := res.GetTotal()
+		if s.shards == 1 {
+			s.total = total
+		}
+		s.timedOut = s.timedOut || res.GetIncompleteResults()
+		// Before this page counts against them
+		wanted, found := s.remaining, s.found
+		if err := s.deliver(res.CodeResults); err != nil {
+			return nil, err
+		}
+
+		// Only worth splitting when the cap is what stands in the way. Files
+		// found so far may be among the ones this shard can reach.
+		if opts.Page == 1 && total > searchCap && wanted+found > searchCap {
+			split, err := s.split(ctx, sh)
+			if err != nil || len(split) > 0 {
+				return split, err
+			}
+			v("can't split %q any further: %d results are out of reach", query, total-searchCap)
+			s.unreachable += total - searchCap
+		}
+
+		rx := len(res.CodeResults)
+		soFar += rx
+		opts.Page += 1
+		v("Fetched: %d/%d", soFar, total)
+		if soFar >= min(total, searchCap) || rx == 0 {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// deliver new results, up to what --limit still allows
+func (s *searcher) deliver(results []*github.CodeResult) error {
+	page := []*github.CodeResult{}
+	for _, cr := range results {
+		if len(page) >= s.remaining {
+			break
+		}
+		repo := cr.GetRepository()
+		key := FileKey{Owner: repo.GetOwner().GetLogin(), Name: repo.GetName(), Path: cr.GetPath()}
+		if _, ok := s.seen[key]; ok {
+			continue
+		}
+		s.seen[key] = struct{}{}
+		page = append(page, cr)
+	}
+	s.remaining -= len(page)
+	s.found += len(page)
+	if len(page) == 0 {
+		return nil
+	}
+	return s.fn(page)
+}
+
+func (s *searcher) split(ctx context.Context, sh shard) ([]shard, error) {
+	for _, split := range s.splitters {
+		shards, err := split(ctx, sh)
+		if err != nil || len(shards) > 0 {
+			return shards, err
+		}
+	}
+	return nil, nil
+}
+
+// splitBySize in half, until a range is a single size
+func (s *searcher) splitBySize(ctx context.Context, sh shard) ([]shard, error) {
+	if sh.minSize >= sh.maxSize {
+		return nil, nil
+	}
+	mid := sh.minSize + (sh.maxSize-sh.minSize)/2
+	lower, upper := sh, sh
+	lower.maxSize = mid
+	upper.minSize = mid + 1
+	return []shard{lower, upper}, nil
+}
+
+// splitByRepo into one shard per repo the searched org or user has
+func (s *searcher) splitByRepo(ctx context.Context, sh shard) ([]shard, error) {
+	m := queryOwnerRe.FindStringSubmatch(s.query)
+	if sh.repo != "" || m == nil || strings.Contains(s.query, "repo:") {
+		return nil, nil
+	}
+	if s.repos == nil {
+		repos, err := s.listRepos(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		s.repos = repos
+	}
+
+	shards := []shard{}
+	for _, repo := range s.repos {
+		split := sh
+		split.repo = repo
+		shards = append(shards, split)
+	}
+	return shards, nil
+}
+
+// listRepos of an org, or of a user when it isn't one
+//
+// Forks are left out: code search skips them too.
+func (s *searcher) listRepos(ctx context.Context, owner string) ([]string, error) {
+	v("Listing repos of %s to shard the search", owner)
+	repos := []string{}
+	opts := github.ListOptions{PerPage: 100}
+	byUser := false
+	for {
+		var page []*github.Repository
+		var resp *github.Response
+		var err error
+		if byUser {
+			page, resp, err = s.client.Repositories.List(ctx, owner, &github.RepositoryListOptions{ListOptions: opts})
+		} else {
+			page, resp, err = s.client.Repositories.ListByOrg(ctx, owner, &github.RepositoryListByOrgOptions{ListOptions: opts})
+		}
+		var apiErr *apiError
+		if err = githubError(err); errors.As(err, &apiErr) && apiErr.kind == failureNotFound && !byUser {
+			byUser = true
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("couldn't list repos of %s: %w", owner, err)
+		}
+
+		for _, repo := range page {
+			if !repo.GetFork() {
+				repos = append(repos, repo.GetFullName())
+			}
+		}
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// splitByExt into common extensions and everything else
+func (s *searcher) splitByExt(ctx context.Context, sh shard) ([]shard, error) {
+	if sh.ext != "" || sh.otherExts || strings.Contains(s.query, "extension:") {
+		return nil, nil
+	}
+	shards := []shard{}
+	for _, ext := range shardExtensions {
+		split := sh
+		split.ext = ext
+		shards = append(shards, split)
+	}
+	rest := sh
+	rest.otherExts = true
+	return append(shards, rest), nil
+}
+
+// report whether a sharded search found everything
+func (s *searcher) report() {
+	switch {
+	case s.unreachable > 0:
+		w("GitHub returns at most %d results per search and ~%d files couldn't be split out into smaller ones: results are incomplete", searchCap, s.unreachable)
+	case s.timedOut:
+		w("GitHub timed out on part of the search: results may be incomplete")
+	case s.total > s.found && s.remaining > 0:
+		w("GitHub counted %d files but only %d could be found: results are incomplete", s.total, s.found)
+	case s.shards > 1 && s.remaining > 0:
+		fmt.Fprintln(os.Stderr, color.New(color.Faint).Sprintf("split the search into %d to get past GitHub's cap of %d: found all %d files", s.shards, searchCap, s.found))
+	}
+}
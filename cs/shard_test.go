@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v47/github"
+)
+
+type fakeFile struct {
+	repo, path string
+	size       int
+}
+
+// fakeIndex answers code searches over files, honoring the qualifiers shards
+// use and GitHub's cap, and lists the repos of org "myorg"
+func fakeIndex(t *testing.T, files []fakeFile) *github.Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/search/code", func(rw http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+
+		found := []fakeFile{}
+	files:
+		for _, f := range files {
+			for _, q := range strings.Fields(r.URL.Query().Get("q")) {
+				name, value, _ := strings.Cut(q, ":")
+				ext := f.path[strings.LastIndex(f.path, ".")+1:]
+				var lo, hi int
+				fmt.Sscanf(value, "%d..%d", &lo, &hi)
+				switch {
+				case name == "size" && (f.size < lo || f.size > hi),
+					name == "repo" && f.repo != value,
+					name == "extension" && ext != value,
+					name == "-extension" && ext == value:
+					continue files
+				}
+			}
+			found = append(found, f)
+		}
+
+		items := []map[string]any{}
+		for i := (page - 1) * perPage; i < min(min(page*perPage, len(found)), searchCap); i++ {
+			owner, name, _ := strings.Cut(found[i].repo, "/")
+			items = append(items, map[string]any{
+				"path":       found[i].path,
+				"repository": map[string]any{"name": name, "owner": map[string]any{"login": owner}},
+			})
+		}
+		json.NewEncoder(rw).Encode(map[string]any{"total_count": len(found), "items": items})
+	})
+	mux.HandleFunc("/api/v3/orgs/myorg/repos", func(rw http.ResponseWriter, r *http.Request) {
+		repos := []map[string]any{}
+		seen := map[string]bool{}
+		for _, f := range files {
+			if !seen[f.repo] {
+				seen[f.repo] = true
+				repos = append(repos, map[string]any{"full_name": f.repo})
+			}
+		}
+		json.NewEncoder(rw).Encode(repos)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client, err := github.NewEnterpriseClient(srv.URL, srv.URL, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func runSearcher(t *testing.T, client *github.Client, query string, limit int) (*searcher, map[string]int) {
+	got := map[string]int{}
+	s, err := newSearcher(client, query, limit, func(page []*github.CodeResult) error {
+		for _, cr := range page {
+			got[cr.GetRepository().GetOwner().GetLogin()+"/"+cr.GetRepository().GetName()+" "+cr.GetPath()]++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, got
+}
+
+func TestSearcherShardsBySize(t *testing.T) {
+	files := []fakeFile{}
+	for i := 0; i < 2500; i++ {
+		files = append(files, fakeFile{repo: "myorg/big", path: fmt.Sprintf("f%04d.go", i), size: i * 37 % 20000})
+	}
+	client := fakeIndex(t, files)
+
+	s, got := runSearcher(t, client, "Foo org:myorg", 5000)
+	if len(got) != len(files) || s.found != len(files) {
+		t.Errorf("expected all %d files, got: %d", len(files), len(got))
+	}
+	for key, n := range got {
+		if n > 1 {
+			t.Errorf("%s: reported %d times", key, n)
+		}
+	}
+	if s.shards < 3 || s.unreachable != 0 {
+		t.Errorf("expected a complete, sharded search, got: %d shards, %d unreachable", s.shards, s.unreachable)
+	}
+
+	// Under the cap, a limit means what it always has
+	_, got = runSearcher(t, client, "Foo org:myorg", 150)
+	if len(got) != 150 {
+		t.Errorf("expected --limit results, got: %d", len(got))
+	}
+}
+
+func TestSearcherShardsJustPastCap(t *testing.T) {
+	files := []fakeFile{}
+	for i := 0; i < 2500; i++ {
+		files = append(files, fakeFile{repo: "myorg/big", path: fmt.Sprintf("f%04d.go", i), size: i * 37 % 20000})
+	}
+	client := fakeIndex(t, files)
+
+	// The first page is already in hand when deciding to shard
+	for _, limit := range []int{1001, 1050, 1100} {
+		s, got := runSearcher(t, client, "Foo org:myorg", limit)
+		if len(got) != limit || s.shards < 3 {
+			t.Errorf("limit %d: expected a sharded search to fill it, got: %d files from %d shards", limit, len(got), s.shards)
+		}
+	}
+}
+
+func TestSearcherShardsByRepo(t *testing.T) {
+	// Same size everywhere, so only repos can split them
+	files := []fakeFile{}
+	for _, repo := range []string{"myorg/a", "myorg/b", "myorg/c"} {
+		for i := 0; i < 700; i++ {
+			files = append(files, fakeFile{repo: repo, path: fmt.Sprintf("f%03d.go", i), size: 10})
+		}
+	}
+	client := fakeIndex(t, files)
+
+	s, got := runSearcher(t, client, "Foo org:myorg", 5000)
+	if len(got) != len(files) || s.unreachable != 0 {
+		t.Errorf("expected all %d files, got: %d (%d unreachable)", len(files), len(got), s.unreachable)
+	}
+
+	// Without an org there's nothing to list, so some are out of reach
+	s, got = runSearcher(t, client, "Foo", 5000)
+	if len(got) != searchCap || s.unreachable != len(files)-searchCap {
+		t.Errorf("expected %d files and %d unreachable, got: %d and %d", searchCap, len(files)-searchCap, len(got), s.unreachable)
+	}
+}
+
+func TestSearcherShardsByExt(t *testing.T) {
+	flags.shardBy = "ext"
+	defer func() { flags.shardBy = "size" }()
+
+	files := []fakeFile{}
+	for i, ext := range []string{"go", "py", "yaml"} {
+		for j := 0; j < 600; j++ {
+			files = append(files, fakeFile{repo: "myorg/a", path: fmt.Sprintf("f%03d.%s", j, ext), size: i})
+		}
+	}
+	client := fakeIndex(t, files)
+
+	s, got := runSearcher(t, client, "Foo", 5000)
+	if len(got) != len(files) || s.unreachable != 0 {
+		t.Errorf("expected all %d files, got: %d (%d unreachable)", len(files), len(got), s.unreachable)
+	}
+	// The root, a shard per extension, and one for the rest
+	if s.shards != len(shardExtensions)+2 {
+		t.Errorf("expected %d shards, got: %d", len(shardExtensions)+2, s.shards)
+	}
+}
+
+func TestShardQualifiers(t *testing.T) {
+	tests := []struct {
+		shard shard
+		want  string
+	}{
+		{rootShard(), ""},
+		{shard{minSize: 0, maxSize: 1024}, "size:0..1024"},
+		{shard{minSize: 0, maxSize: maxIndexedSize, repo: "a/b", ext: "go"}, "repo:a/b extension:go"},
+	}
+	for _, tt := range tests {
+		if got := tt.shard.qualifiers(); got != tt.want {
+			t.Errorf("%+v: expected %q, got: %q", tt.shard, tt.want, got)
+		}
+	}
+	rest := rootShard()
+	rest.otherExts = true
+	if got := rest.qualifiers(); !strings.HasPrefix(got, "-extension:go -extension:py") {
+		t.Errorf("expected every shard extension excluded, got: %q", got)
+	}
+}
@@ -0,0 +1,226 @@
+// Snapshots
+//
+// '--dump' is for writing tests in Go. Snapshots are the same data as JSON,
+// versioned so old files keep working, and 'cs render' replays them with any
+// output flags and no network. Good for demos, sharing exact results, and
+// fixtures.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// Bump when the format changes in a way older readers can't handle
+const snapshotVersion = 1
+
+var renderCmd = &cobra.Command{
+	Use:   "render FILE [flags]",
+	Short: "Re-render a snapshot saved with --save-snapshot, offline",
+	Long: `
+Re-render a snapshot saved with --save-snapshot, offline
+
+Any output flag works: context, greppable, URLs, function names, listings.
+Scope flags (--org, --repo, --path, --ext, --filename) filter the snapshot
+instead of searching. --limit applies as usual.
+
+	cs OldClient --lang go --save-snapshot old-client.json
+	cs render old-client.json -C3
+	cs render old-client.json --repo billing -G
+	cs render old-client.json --files-only
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeRender,
+}
+
+func init() {
+	renderCmd.Flags().BoolVarP(&flags.onlyFiles, "files-only", "l", false, "print only filenames of matches to stdout")
+	renderCmd.Flags().BoolVar(&flags.onlyRepos, "repos-only", false, "print only repository names containing matches to stdout")
+	renderCmd.Flags().BoolVar(&flags.onlyFullNames, "full-names-only", false, "print only fully-qualified repo names to stdout (your/repo path/to/README.md)")
+	renderCmd.Flags().BoolVar(&flags.dumpData, "dump", false, "dump result structures to stdout")
+	rootCmd.AddCommand(renderCmd)
+}
+
+type snapshot struct {
+	Version int       `json:"version"`
+	Query   string    `json:"query"`
+	Taken   time.Time `json:"taken"`
+	// Keyed by owner/name
+	DefaultBranches map[string]string `json:"default_branches"`
+	Files           []snapshotFile    `json:"files"`
+}
+
+// snapshotFile flattens what's keyed by FileKey, since JSON keys are strings
+type snapshotFile struct {
+	Owner       string      `json:"owner"`
+	Name        string      `json:"name"`
+	Path        string      `json:"path"`
+	TextMatches []TextMatch `json:"text_matches"`
+	Content     string      `json:"content"`
+	Truncated   bool        `json:"truncated,omitempty"`
+	Binary      bool        `json:"binary,omitempty"`
+	// Why it couldn't be fetched, if it couldn't
+	Failed string `json:"failed,omitempty"`
+}
+
+func newSnapshot(query string, searchResult SearchResult, defaultBranches map[string]string, fullText FullText) snapshot {
+	keys := []FileKey{}
+	for key := range searchResult {
+		keys = append(keys, key)
+	}
+	sort.Sort(FileKeys(keys))
+
+	snap := snapshot{
+		Version:         snapshotVersion,
+		Query:           strings.TrimSpace(query),
+		Taken:           time.Now().UTC(),
+		DefaultBranches: defaultBranches,
+		Files:           []snapshotFile{},
+	}
+	for _, key := range keys {
+		f := snapshotFile{
+			Owner:       key.Owner,
+			Name:        key.Name,
+			Path:        key.Path,
+			TextMatches: searchResult[key],
+			Content:     fullText.Values[key],
+			Truncated:   fullText.Truncated[key],
+			Binary:      fullText.Binary[key],
+		}
+		if err := fullText.Failed[key]; err != nil {
+			f.Failed = err.Error()
+		}
+		snap.Files = append(snap.Files, f)
+	}
+	return snap
+}
+
+// unpack into the structures the rest of the pipeline uses
+func (s snapshot) unpack() (SearchResult, map[string]string, FullText) {
+	searchResult := SearchResult{}
+	fullText := FullText{Values: map[FileKey]string{}, Truncated: map[FileKey]bool{}, Binary: map[FileKey]bool{}, Failed: map[FileKey]error{}}
+	for _, f := range s.Files {
+		key := FileKey{Owner: f.Owner, Name: f.Name, Path: f.Path}
+		searchResult[key] = f.TextMatches
+		fullText.Values[key] = f.Content
+		if f.Truncated {
+			fullText.Truncated[key] = true
+		}
+		if f.Binary {
+			fullText.Binary[key] = true
+		}
+		if f.Failed != "" {
+			fullText.Failed[key] = snapshotError(f.Failed)
+		}
+	}
+	defaultBranches := s.DefaultBranches
+	if defaultBranches == nil {
+		defaultBranches = map[string]string{}
+	}
+	return searchResult, defaultBranches, fullText
+}
+
+// snapshotError back into the error it was saved from, so errors.Is still works
+func snapshotError(msg string) error {
+	for _, err := range []error{errNoBranch, errNotOnBranch} {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
+
+func saveSnapshot(filename string, s snapshot) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(b, '\n'), 0o644)
+}
+
+func loadSnapshot(filename string) (snapshot, error) {
+	var s snapshot
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("%s isn't a snapshot: %w", filename, err)
+	}
+	if s.Version == 0 {
+		return s, fmt.Errorf("%s isn't a snapshot: missing version", filename)
+	}
+	if s.Version > snapshotVersion {
+		return s, fmt.Errorf("%s is snapshot version %d but this cs only understands up to %d: try upgrading", filename, s.Version, snapshotVersion)
+	}
+	return s, nil
+}
+
+func executeRender(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	snap, err := loadSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+	v("Query: %s", snap.Query)
+	v("Taken: %s", snap.Taken.Local().Format("2006-01-02 15:04"))
+	if flags.lang != "" {
+		w("--lang can't be applied to a snapshot: ignoring it")
+	}
+
+	searchResult, defaultBranches, fullText := snap.unpack()
+	for key := range searchResult {
+		if !inLocalScope(key) {
+			delete(searchResult, key)
+		}
+	}
+
+	if printListing(searchResult) {
+		return matchStatus(len(searchResult))
+	}
+	if flags.dumpData {
+		return dumpData(searchResult, defaultBranches, fullText)
+	}
+	matches := createMatches(searchResult, fullText, defaultBranches)
+	printMatches(matches)
+	return matchStatus(len(matches))
+}
+
+// inLocalScope applies scope flags to a file we already have, the way the
+// search qualifiers would have
+func inLocalScope(key FileKey) bool {
+	if flags.org != "" && !strings.EqualFold(key.Owner, flags.org) {
+		return false
+	}
+	if flags.repo != "" {
+		if strings.Contains(flags.repo, "/") && !strings.EqualFold(key.RepoString(), flags.repo) {
+			return false
+		} else if !strings.Contains(flags.repo, "/") && !strings.EqualFold(key.Name, flags.repo) {
+			return false
+		}
+	}
+	if flags.path != "" {
+		dir := strings.Trim(flags.path, "/")
+		if dir != "" && !strings.HasPrefix(key.Path, dir+"/") {
+			return false
+		}
+	}
+	if flags.ext != "" && path.Ext(key.Path) != "."+strings.TrimPrefix(flags.ext, ".") {
+		return false
+	}
+	if flags.filename != "" && path.Base(key.Path) != flags.filename {
+		return false
+	}
+	return true
+}
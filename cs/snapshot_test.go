@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	key := FileKey{Owner: "coxley", Name: "codesearch", Path: "cs/main.go"}
+	other := FileKey{Owner: "coxley", Name: "other", Path: "big.txt"}
+	binary := FileKey{Owner: "coxley", Name: "other", Path: "logo.png"}
+	moved := FileKey{Owner: "coxley", Name: "other", Path: "moved.go"}
+	searchResult := SearchResult{
+		key:    {{Fragment: "func main() {", Indices: [][2]int{{5, 9}}}},
+		other:  {},
+		binary: {},
+		moved:  {},
+	}
+	defaultBranches := map[string]string{"coxley/codesearch": "master", "coxley/other": "main"}
+	fullText := FullText{
+		Values:    map[FileKey]string{key: "package main\n\nfunc main() {\n}\n", other: "partial", binary: "", moved: ""},
+		Truncated: map[FileKey]bool{other: true},
+		Binary:    map[FileKey]bool{binary: true},
+		Failed:    map[FileKey]error{moved: errNotOnBranch},
+	}
+
+	filename := filepath.Join(t.TempDir(), "snap.json")
+	if err := saveSnapshot(filename, newSnapshot("main language:go ", searchResult, defaultBranches, fullText)); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := loadSnapshot(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Query != "main language:go" || snap.Version != snapshotVersion {
+		t.Errorf("unexpected header: %q version %d", snap.Query, snap.Version)
+	}
+
+	gotResult, gotBranches, gotText := snap.unpack()
+	if !reflect.DeepEqual(gotResult, searchResult) {
+		t.Errorf("expected: %#v, got: %#v", searchResult, gotResult)
+	}
+	if !reflect.DeepEqual(gotBranches, defaultBranches) {
+		t.Errorf("expected: %v, got: %v", defaultBranches, gotBranches)
+	}
+	if !reflect.DeepEqual(gotText, fullText) {
+		t.Errorf("expected: %#v, got: %#v", fullText, gotText)
+	}
+}
+
+func TestLoadSnapshotVersions(t *testing.T) {
+	dir := t.TempDir()
+	td := map[string]string{
+		`{"files": []}`:              "missing version",
+		`{"version": 99}`:            "version 99",
+		`[1, 2, 3]`:                  "isn't a snapshot",
+		`{"version": 1, "files": 3}`: "isn't a snapshot",
+	}
+	for content, expected := range td {
+		filename := filepath.Join(dir, "snap.json")
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSnapshot(filename); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected an error mentioning %q, got: %v", content, expected, err)
+		}
+	}
+}
+
+func TestInLocalScope(t *testing.T) {
+	key := FileKey{Owner: "coxley", Name: "codesearch", Path: "cs/cmd/main.go"}
+	td := []struct {
+		org, repo, path, ext, filename string
+		expected                       bool
+	}{
+		{expected: true},
+		{org: "Coxley", expected: true},
+		{org: "someone", expected: false},
+		{repo: "codesearch", expected: true},
+		{repo: "coxley/codesearch", expected: true},
+		{repo: "other/codesearch", expected: false},
+		{path: "cs", expected: true},
+		{path: "/cs/cmd/", expected: true},
+		{path: "c", expected: false},
+		{ext: "go", expected: true},
+		{ext: ".go", expected: true},
+		{ext: "py", expected: false},
+		{filename: "main.go", expected: true},
+		{filename: "main", expected: false},
+	}
+	defer func() {
+		flags.org, flags.repo, flags.path, flags.ext, flags.filename = "", "", "", "", ""
+	}()
+	for _, tc := range td {
+		flags.org, flags.repo, flags.path, flags.ext, flags.filename = tc.org, tc.repo, tc.path, tc.ext, tc.filename
+		if got := inLocalScope(key); got != tc.expected {
+			t.Errorf("%+v: expected: %v, got: %v", tc, tc.expected, got)
+		}
+	}
+}
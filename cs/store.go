@@ -0,0 +1,98 @@
+// Local state
+//
+// Saved searches and anything else we remember between runs live as JSON
+// files under 'data_dir' (~/.codesearch_data by default). One file per thing
+// keeps them easy to inspect, copy, or delete by hand.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var storeNameRe = regexp.MustCompile(`^[\w.-]+$`)
+
+// dataDir returns a subdirectory of 'data_dir', creating it if needed
+func dataDir(sub string) (string, error) {
+	dir := viper.GetString("data_dir")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("couldn't determine your home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".codesearch_data")
+	}
+	dir = filepath.Join(dir, sub)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("couldn't create %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// storePath of a named item, which must be safe to use as a filename
+func storePath(sub, name string) (string, error) {
+	if !storeNameRe.MatchString(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("names may only contain letters, digits, '_', '-', and '.': %q", name)
+	}
+	dir, err := dataDir(sub)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// storeNames of everything saved in a subdirectory, sorted
+func storeNames(sub string) ([]string, error) {
+	dir, err := dataDir(sub)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, p := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(p), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// errNotStored is returned when loading something that was never saved
+var errNotStored = errors.New("not found")
+
+func loadJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return errNotStored
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("couldn't parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// saveJSON by writing to a temporary file first so an interrupted save
+// doesn't lose what was there
+func saveJSON(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
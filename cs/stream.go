@@ -0,0 +1,161 @@
+// Streaming results
+//
+// Search results come back a page at a time. Rather than wait for all of
+// them, each page has its branches looked up, its files fetched, and its
+// matches printed while the next page is being searched for. Output order
+// follows GitHub's ranking across pages; --sort waits for everything and
+// prints by repo and path instead.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+)
+
+// streamResults of a search to stdout a page at a time, reporting how many
+// files or matches were printed
+func streamResults(ctx context.Context, client *http.Client, query string, olderThan, newerThan time.Time) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Room for one page so the next search overlaps the current fetch
+	pages := make(chan []*github.CodeResult, 1)
+	searchErr := make(chan error, 1)
+	go func() {
+		defer close(pages)
+		_, err := searchPages(ctx, query, flags.limit, func(page []*github.CodeResult) error {
+			select {
+			case pages <- page:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		searchErr <- err
+	}()
+
+	s := pageStream{
+		client:    client,
+		olderThan: olderThan,
+		newerThan: newerThan,
+		remaining: flags.limit,
+		branches:  map[string]string{},
+		resolved:  map[string]bool{},
+		listed:    listing{},
+		fetched:   SearchResult{},
+		fullText:  FullText{Values: map[FileKey]string{}, Truncated: map[FileKey]bool{}, Binary: map[FileKey]bool{}},
+	}
+	for page := range pages {
+		if err := s.handle(coerceResults(page)); err != nil {
+			return s.found, err
+		}
+	}
+	if err := <-searchErr; err != nil {
+		return s.found, err
+	}
+	if len(s.fetched) > 0 {
+		recordSearch(query, s.fetched, s.branches, s.fullText)
+	}
+	return s.found, nil
+}
+
+// pageStream is what carries over from one page to the next
+type pageStream struct {
+	client               *http.Client
+	olderThan, newerThan time.Time
+
+	// Fragments --limit still allows
+	remaining int
+	found     int
+	printed   bool
+
+	branches map[string]string
+	// Repos already looked up, including ones without a branch
+	resolved map[string]bool
+	listed   listing
+
+	// Everything fetched so far, kept for --offline
+	fetched  SearchResult
+	fullText FullText
+}
+
+// handle a page: resolve branches of repos not seen yet, fetch its files, and
+// print what matched
+func (s *pageStream) handle(page SearchResult) error {
+	if len(page) == 0 {
+		return nil
+	}
+	if s.listed.print(page) {
+		s.found += len(page)
+		return nil
+	}
+	if flags.limit > 0 && s.remaining <= 0 {
+		return nil
+	}
+
+	unresolved := SearchResult{}
+	for key, tms := range page {
+		if !s.resolved[key.RepoString()] {
+			unresolved[key] = tms
+		}
+	}
+	if len(unresolved) > 0 {
+		branches, err := getDefaultBranches(s.client, unresolved)
+		if err != nil {
+			return err
+		}
+		for key := range unresolved {
+			s.resolved[key.RepoString()] = true
+		}
+		for repo, branch := range branches {
+			s.branches[repo] = branch
+		}
+	}
+
+	fullText, err := fetchFullText(s.client, page, s.branches)
+	if err != nil {
+		return err
+	}
+	for key, tms := range page {
+		s.fetched[key] = tms
+	}
+	for k, v := range fullText.Values {
+		s.fullText.Values[k] = v
+	}
+	for k, v := range fullText.Truncated {
+		s.fullText.Truncated[k] = v
+	}
+	for k, v := range fullText.Binary {
+		s.fullText.Binary[k] = v
+	}
+
+	matches, shown := createMatchesUpTo(page, fullText, s.branches, s.remaining)
+	s.remaining -= shown
+
+	if flags.blame || !s.olderThan.IsZero() || !s.newerThan.IsZero() {
+		blames, err := getBlame(s.client, matchedFiles(matches), s.branches)
+		if err != nil {
+			return err
+		}
+		annotateBlame(matches, blames)
+		if !s.olderThan.IsZero() || !s.newerThan.IsZero() {
+			matches = filterByAge(matches, s.olderThan, s.newerThan)
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	// printMatches separates files within a page, this separates pages
+	if s.printed && !flags.greppable {
+		fmt.Println()
+	}
+	printMatches(matches)
+	s.printed = true
+	s.found += len(matches)
+	return nil
+}
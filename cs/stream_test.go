@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// fakeSearchServer answers code searches with total files named f000.go onwards,
+// each matching on its own name. Before answering page 2 it waits for the
+// contents of page 1 to be asked for.
+func fakeSearchServer(t *testing.T, total int) string {
+	fullText, _, _ := fakeFullText(t, 100)
+	t.Cleanup(fullText.Close)
+	fetched := make(chan struct{})
+	var once sync.Once
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(rw http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(fetched) })
+		fullText.Config.Handler.ServeHTTP(rw, r)
+	})
+	mux.HandleFunc("/api/v3/search/code", func(rw http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if page > 1 {
+			select {
+			case <-fetched:
+			case <-time.After(5 * time.Second):
+				t.Errorf("page %d was searched for before page 1 was fetched", page)
+			}
+		}
+
+		items := []map[string]any{}
+		for i := (page - 1) * perPage; i < min(page*perPage, total); i++ {
+			path := fmt.Sprintf("f%03d.go", i)
+			items = append(items, map[string]any{
+				"path":       path,
+				"repository": map[string]any{"name": "codesearch", "owner": map[string]any{"login": "coxley"}},
+				"text_matches": []map[string]any{{
+					"object_type": "FileContent",
+					"property":    "content",
+					"fragment":    path,
+					"matches":     []map[string]any{{"text": path, "indices": []int{0, len(path)}}},
+				}},
+			})
+		}
+		json.NewEncoder(rw).Encode(map[string]any{"total_count": total, "items": items})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// withStdout redirected to a file, returning what was written
+func withStdout(t *testing.T, fn func()) string {
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := os.Stdout
+	os.Stdout = f
+	fn()
+	os.Stdout = prev
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestStreamResults(t *testing.T) {
+	srv := fakeSearchServer(t, 150)
+	prevURL, prevBranch, prevToken := viper.Get("base_url"), viper.Get("defaultBranch"), token
+	viper.Set("base_url", srv)
+	viper.Set("defaultBranch", "main")
+	viper.Set("data_dir", t.TempDir())
+	token = "test"
+	defer func() {
+		viper.Set("base_url", prevURL)
+		viper.Set("defaultBranch", prevBranch)
+		viper.Set("data_dir", nil)
+		token = prevToken
+	}()
+	flags.limit, flags.concurrency, flags.greppable = 140, 2, true
+	defer func() { flags.limit, flags.concurrency, flags.greppable = 30, 0, false }()
+
+	var found int
+	var err error
+	out := withStdout(t, func() {
+		found, err = streamResults(context.Background(), http.DefaultClient, "f", time.Time{}, time.Time{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 140 {
+		t.Errorf("expected --limit to hold across pages, got %d matches", found)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != found {
+		t.Fatalf("expected a line per match, got %d:\n%s", len(lines), out)
+	}
+	if !strings.HasPrefix(lines[0], "coxley/codesearch:f000.go:1:") || !strings.HasPrefix(lines[139], "coxley/codesearch:f139.go:1:") {
+		t.Errorf("expected results in the order they were found, got: %q ... %q", lines[0], lines[139])
+	}
+}
+
+func TestListingAcrossPages(t *testing.T) {
+	flags.onlyRepos = true
+	defer func() { flags.onlyRepos = false }()
+
+	seen := listing{}
+	out := withStdout(t, func() {
+		seen.print(SearchResult{{Owner: "coxley", Name: "codesearch", Path: "a.go"}: nil})
+		seen.print(SearchResult{
+			{Owner: "coxley", Name: "codesearch", Path: "b.go"}: nil,
+			{Owner: "coxley", Name: "dotfiles", Path: "b.go"}:   nil,
+		})
+	})
+	if got := strings.Count(out, "\n"); got != 2 {
+		t.Errorf("expected each repo once, got:\n%s", out)
+	}
+}
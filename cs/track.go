@@ -0,0 +1,342 @@
+// Migration progress
+//
+// Deprecations are tracked by re-running the same search every week or so
+// and writing down the numbers. 'cs track' does the writing down, and draws
+// the trend with what's left per repo.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var trackCmd = &cobra.Command{
+	Use:   "track NAME [terms]",
+	Short: "Record how many matches, files, and repos a search has over time",
+	Long: `
+Record how many matches, files, and repos a search has over time
+
+The first run saves the search under NAME. After that, 'cs track NAME' records
+another data point with the same search and --limit. Run it from cron, or
+whenever you're curious. Use a --limit large enough to see everything.
+
+	cs track old-client OldClient --lang go --limit 500
+	cs track old-client
+	cs track show old-client
+	cs track show old-client --format csv > progress.csv
+	`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: executeTrack,
+}
+
+var trackShowCmd = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show the trend of a tracked search and what remains per repo",
+	Args:  cobra.ExactArgs(1),
+	RunE:  executeTrackShow,
+}
+
+var trackListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tracked searches",
+	Args:  cobra.NoArgs,
+	RunE:  executeTrackList,
+}
+
+var trackFlags = struct {
+	format string
+}{}
+
+func init() {
+	trackShowCmd.Flags().StringVar(&trackFlags.format, "format", "text", "output as text or csv")
+	trackCmd.AddCommand(trackShowCmd, trackListCmd)
+	rootCmd.AddCommand(trackCmd)
+}
+
+type tracker struct {
+	Name    string       `json:"name"`
+	Query   string       `json:"query"`
+	Limit   int          `json:"limit"`
+	Created time.Time    `json:"created"`
+	Points  []trackPoint `json:"points"`
+}
+
+type trackPoint struct {
+	Time    time.Time      `json:"time"`
+	Matches int            `json:"matches"`
+	Files   int            `json:"files"`
+	Repos   int            `json:"repos"`
+	PerRepo map[string]int `json:"per_repo"`
+	// Matches and repos are only what --limit let through
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func executeTrack(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	name, terms := args[0], args[1:]
+	path, err := storePath("tracks", name)
+	if err != nil {
+		return err
+	}
+
+	var t tracker
+	err = loadJSON(path, &t)
+	switch {
+	case errors.Is(err, errNotStored) && len(terms) == 0:
+		return fmt.Errorf("%s isn't tracked yet: give it search terms", name)
+	case errors.Is(err, errNotStored):
+		t = tracker{Name: name, Query: makeQuery(terms), Limit: flags.limit, Created: time.Now()}
+	case err != nil:
+		return err
+	case len(terms) > 0 && makeQuery(terms) != t.Query:
+		return fmt.Errorf("%s already tracks %q: pick another name to track something else", name, t.Query)
+	}
+	if cmd.Flags().Changed("limit") {
+		t.Limit = flags.limit
+	}
+
+	if flags.showQuery {
+		fmt.Println(t.Query)
+		return nil
+	}
+	v("Query: %s", t.Query)
+
+	run, err := runSavedSearch(cmd.Context(), savedSearch{Name: t.Name, Query: t.Query, Limit: t.Limit})
+	if err != nil {
+		return err
+	}
+	point := countMatches(run, time.Now())
+	t.Points = append(t.Points, point)
+	if err := saveJSON(path, t); err != nil {
+		return fmt.Errorf("couldn't save %s: %w", name, err)
+	}
+
+	summary := fmt.Sprintf("%s: %d matches in %d files across %d repos", name, point.Matches, point.Files, point.Repos)
+	if len(t.Points) > 1 {
+		prev := t.Points[len(t.Points)-2]
+		summary += fmt.Sprintf(" (%+d since %s)", point.Matches-prev.Matches, prev.Time.Format("2006-01-02"))
+	}
+	fmt.Println(summary)
+	if point.Truncated {
+		w("%s: matches and repos were capped by --limit %d: raise it to count everything", name, t.Limit)
+	}
+	return nil
+}
+
+func executeTrackShow(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	t, err := loadTracker(args[0])
+	if err != nil {
+		return err
+	}
+	if len(t.Points) == 0 {
+		return fmt.Errorf("%s has no data points yet: run 'cs track %s'", t.Name, t.Name)
+	}
+
+	switch trackFlags.format {
+	case "text":
+		if err := printTrend(t); err != nil {
+			return err
+		}
+	case "csv":
+		header, rows := trendRows(t)
+		if err := writeRows("csv", header, rows); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", trackFlags.format)
+	}
+	return nil
+}
+
+func executeTrackList(cmd *cobra.Command, args []string) error {
+	names, err := storeNames("tracks")
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, name := range names {
+		t, err := loadTracker(name)
+		if err != nil {
+			return err
+		}
+		matches, last := "", ""
+		if len(t.Points) > 0 {
+			p := t.Points[len(t.Points)-1]
+			matches = fmt.Sprint(p.Matches)
+			last = p.Time.Format("2006-01-02 15:04")
+		}
+		rows = append(rows, []string{t.Name, fmt.Sprint(len(t.Points)), matches, last, t.Query})
+	}
+	return writeRows("table", []string{"NAME", "POINTS", "MATCHES", "LAST RUN", "QUERY"}, rows)
+}
+
+func loadTracker(name string) (tracker, error) {
+	path, err := storePath("tracks", name)
+	if err != nil {
+		return tracker{}, err
+	}
+	var t tracker
+	if err := loadJSON(path, &t); errors.Is(err, errNotStored) {
+		return t, fmt.Errorf("%s isn't tracked", name)
+	} else if err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// countMatches of a run into a data point
+//
+// Files are what GitHub counted, which holds past --limit. Matches and repos
+// can only be counted from what was fetched.
+func countMatches(run savedRun, now time.Time) trackPoint {
+	p := trackPoint{
+		Time:      now,
+		Matches:   len(run.matches),
+		PerRepo:   map[string]int{},
+		Truncated: run.capped || len(run.failed) > 0,
+	}
+	files := map[string]struct{}{}
+	for _, m := range run.matches {
+		files[m.file()] = struct{}{}
+		p.PerRepo[m.Repo]++
+	}
+	p.Files = max(run.total, len(files))
+	p.Repos = len(p.PerRepo)
+	return p
+}
+
+// atLeast marks counts of truncated points as lower bounds
+func (p trackPoint) atLeast(n int) string {
+	if p.Truncated {
+		return fmt.Sprintf("%d+", n)
+	}
+	return fmt.Sprint(n)
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline of values, scaled from zero to the largest
+func sparkline(values []int) string {
+	var top int
+	for _, n := range values {
+		top = max(top, n)
+	}
+	var b strings.Builder
+	for _, n := range values {
+		i := 0
+		if top > 0 {
+			i = n * (len(sparkBlocks) - 1) / top
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+// bar of width cells for n out of top
+func bar(n, top, width int) string {
+	if top == 0 {
+		return ""
+	}
+	cells := n * width / top
+	if cells == 0 && n > 0 {
+		cells = 1
+	}
+	return strings.Repeat("█", cells)
+}
+
+const barWidth = 40
+
+func printTrend(t tracker) error {
+	counts := []int{}
+	var top int
+	for _, p := range t.Points {
+		counts = append(counts, p.Matches)
+		top = max(top, p.Matches)
+	}
+	first, last := t.Points[0], t.Points[len(t.Points)-1]
+	fmt.Printf("%s  %s\n", color.New(color.Bold).Sprint(t.Name), t.Query)
+	fmt.Printf("%s  %s -> %s matches since %s\n\n", sparkline(counts), first.atLeast(first.Matches), last.atLeast(last.Matches), first.Time.Format("2006-01-02"))
+
+	truncated := false
+	rows := [][]string{}
+	for _, p := range t.Points {
+		truncated = truncated || p.Truncated
+		rows = append(rows, []string{
+			p.Time.Format("2006-01-02 15:04"),
+			p.atLeast(p.Matches),
+			fmt.Sprint(p.Files),
+			p.atLeast(p.Repos),
+			color.CyanString(bar(p.Matches, top, barWidth)),
+		})
+	}
+	if err := writeRows("table", []string{"DATE", "MATCHES", "FILES", "REPOS", ""}, rows); err != nil {
+		return err
+	}
+	if truncated {
+		fmt.Printf("\n+ only counts what --limit %d let through: raise it with 'cs track %s --limit N'\n", t.Limit, t.Name)
+	}
+
+	if last.Matches == 0 && !last.Truncated {
+		fmt.Println("\nNothing left!")
+		return nil
+	}
+	if last.Truncated {
+		fmt.Println("\nRemaining by repo, as far as --limit let us see:")
+	} else {
+		fmt.Println("\nRemaining by repo:")
+	}
+	repos := []string{}
+	for repo := range last.PerRepo {
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		a, b := last.PerRepo[repos[i]], last.PerRepo[repos[j]]
+		if a != b {
+			return a > b
+		}
+		return repos[i] < repos[j]
+	})
+	rows = [][]string{}
+	for _, repo := range repos {
+		n := last.PerRepo[repo]
+		rows = append(rows, []string{repo, fmt.Sprint(n), color.YellowString(bar(n, last.PerRepo[repos[0]], barWidth))})
+	}
+	return writeRows("table", []string{"REPO", "MATCHES", ""}, rows)
+}
+
+// trendRows with a column per repo that's ever matched, for spreadsheets
+func trendRows(t tracker) ([]string, [][]string) {
+	seen := map[string]struct{}{}
+	repos := []string{}
+	for _, p := range t.Points {
+		for repo := range p.PerRepo {
+			if _, ok := seen[repo]; !ok {
+				seen[repo] = struct{}{}
+				repos = append(repos, repo)
+			}
+		}
+	}
+	sort.Strings(repos)
+
+	header := append([]string{"date", "matches", "files", "repos", "truncated"}, repos...)
+	rows := [][]string{}
+	for _, p := range t.Points {
+		row := []string{p.Time.Format(time.RFC3339), fmt.Sprint(p.Matches), fmt.Sprint(p.Files), fmt.Sprint(p.Repos), fmt.Sprint(p.Truncated)}
+		for _, repo := range repos {
+			row = append(row, fmt.Sprint(p.PerRepo[repo]))
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/exp/slices"
+)
+
+func TestCountMatches(t *testing.T) {
+	p := countMatches(savedRun{matches: []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 1},
+		{Repo: "a/x", Path: "main.go", Line: 9},
+		{Repo: "a/x", Path: "util.go", Line: 3},
+		{Repo: "a/y", Path: "main.go", Line: 2},
+	}, total: 3}, time.Time{})
+	if p.Matches != 4 || p.Files != 3 || p.Repos != 2 || p.Truncated {
+		t.Errorf("expected 4 matches, 3 files, 2 repos, got: %+v", p)
+	}
+	if p.PerRepo["a/x"] != 3 || p.PerRepo["a/y"] != 1 {
+		t.Errorf("unexpected per-repo counts: %v", p.PerRepo)
+	}
+}
+
+func TestCountMatchesPastLimit(t *testing.T) {
+	srv := fakeSearchServer(t, 60)
+	prevURL, prevBranch, prevToken := viper.Get("base_url"), viper.Get("defaultBranch"), token
+	viper.Set("base_url", srv)
+	viper.Set("defaultBranch", "main")
+	viper.Set("data_dir", t.TempDir())
+	token = "test"
+	defer func() {
+		viper.Set("base_url", prevURL)
+		viper.Set("defaultBranch", prevBranch)
+		viper.Set("data_dir", nil)
+		token = prevToken
+	}()
+
+	run, err := runSavedSearch(context.Background(), savedSearch{Name: "old-client", Query: "f", Limit: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := countMatches(run, time.Time{})
+	if p.Files != 60 || p.Matches != 30 || !p.Truncated {
+		t.Errorf("expected all 60 files counted and 30 truncated matches, got: %+v", p)
+	}
+
+	out := withStdout(t, func() {
+		if err := printTrend(tracker{Name: "old-client", Limit: 30, Points: []trackPoint{p}}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "30+") || !strings.Contains(out, "--limit 30") {
+		t.Errorf("expected truncated counts to be marked, got:\n%s", out)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	td := map[string][]int{
+		"":         {},
+		"▁▁":       {0, 0},
+		"█▆▄▁":     {40, 30, 20, 0},
+		"▁▂▃▄▅▆▇█": {0, 1, 2, 3, 4, 5, 6, 7},
+	}
+	for expected, values := range td {
+		if got := sparkline(values); got != expected {
+			t.Errorf("%v: expected: %q, got: %q", values, expected, got)
+		}
+	}
+}
+
+func TestBar(t *testing.T) {
+	if got := bar(1, 1000, 10); got != "█" {
+		t.Errorf("expected anything above zero to show, got: %q", got)
+	}
+	if got := bar(0, 10, 10); got != "" {
+		t.Errorf("expected nothing for zero, got: %q", got)
+	}
+	if got := bar(5, 10, 10); got != "█████" {
+		t.Errorf("expected half, got: %q", got)
+	}
+}
+
+func TestTrendRows(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2022, 10, d, 0, 0, 0, 0, time.UTC) }
+	header, rows := trendRows(tracker{Points: []trackPoint{
+		{Time: day(1), Matches: 5, Files: 3, Repos: 2, PerRepo: map[string]int{"a/y": 4, "a/x": 1}},
+		{Time: day(8), Matches: 2, Files: 1, Repos: 1, PerRepo: map[string]int{"a/y": 2}},
+	}})
+
+	if !slices.Equal(header, []string{"date", "matches", "files", "repos", "truncated", "a/x", "a/y"}) {
+		t.Errorf("unexpected header: %v", header)
+	}
+	if len(rows) != 2 || !slices.Equal(rows[1], []string{"2022-10-08T00:00:00Z", "2", "1", "1", "false", "0", "2"}) {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+}
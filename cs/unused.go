@@ -0,0 +1,214 @@
+// Unused exported API
+//
+// Before deleting or changing something a shared library exports, you want to
+// know who outside the library uses it. 'cs unused' answers that for every
+// exported identifier of a Go package at once.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var unusedCmd = &cobra.Command{
+	Use:   "unused OWNER/REPO[/path]",
+	Short: "Find exported Go identifiers of a package that nothing outside its repo uses",
+	Long: `
+Find exported Go identifiers of a package that nothing outside its repo uses
+
+The package source is fetched and parsed for its exported functions, types,
+variables, and constants. Each is then searched for across your scope,
+excluding the repo defining it, and the files found are parsed to confirm they
+select it from the package. Symbols with at most --max-refs files referencing
+them are printed, with links to those files.
+
+Searches are batched, but large packages still take a while when GitHub's
+search rate limit kicks in.
+
+	cs unused coxley/codesearch/cs
+	cs unused coxley/codesearch/cs --max-refs 0
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeUnused,
+}
+
+var unusedFlags = struct {
+	maxRefs int
+}{}
+
+func init() {
+	unusedCmd.Flags().IntVar(&unusedFlags.maxRefs, "max-refs", 2, "show symbols referenced by at most [num] files outside the repo")
+	rootCmd.AddCommand(unusedCmd)
+}
+
+// GitHub allows at most five boolean operators in a query
+const symbolsPerSearch = 6
+
+func executeUnused(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+
+	owner, name, dir, err := parseRepoPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+	pkg, err := loadGoPackage(httpClient, owner, name, dir)
+	if err != nil {
+		return err
+	}
+	exports := pkg.exports()
+	v("%s exports %d symbols", pkg.importPath, len(exports))
+
+	refs, err := externalRefs(ctx, pkg, exports)
+	if err != nil {
+		return err
+	}
+	if flags.showQuery {
+		return nil
+	}
+	printUnused(exports, refs)
+	return nil
+}
+
+// externalRefs maps each exported name to files referencing it outside the
+// package's repo
+//
+// Batching symbols into one query is cheaper, but a popular symbol can crowd
+// the others out of the results. When a batch hits --limit, whatever came
+// back with few references gets a search of its own.
+func externalRefs(ctx context.Context, pkg *goPackage, exports []goExport) (map[string][]FileKey, error) {
+	refs := map[string][]FileKey{}
+	for start := 0; start < len(exports); start += symbolsPerSearch {
+		batch := []string{}
+		for _, e := range exports[start:min(start+symbolsPerSearch, len(exports))] {
+			batch = append(batch, e.name)
+		}
+
+		found, capped, err := searchSymbols(ctx, pkg, batch)
+		if err != nil {
+			return nil, err
+		}
+		if !capped || len(batch) == 1 {
+			for sym, keys := range found {
+				refs[sym] = keys
+			}
+			continue
+		}
+
+		for _, sym := range batch {
+			if len(found[sym]) > unusedFlags.maxRefs {
+				refs[sym] = found[sym]
+				continue
+			}
+			alone, _, err := searchSymbols(ctx, pkg, []string{sym})
+			if err != nil {
+				return nil, err
+			}
+			refs[sym] = alone[sym]
+		}
+	}
+	return refs, nil
+}
+
+// searchSymbols with a single query, attributing each result to the symbols
+// it selects from the package
+//
+// Matching files are fetched and parsed like 'cs usage' does, so mentioning
+// the import path or a symbol's name in passing doesn't count. The boolean
+// reports if there were more results than --limit let us see.
+func searchSymbols(ctx context.Context, pkg *goPackage, batch []string) (map[string][]FileKey, bool, error) {
+	terms := []string{
+		strconv.Quote(pkg.importPath),
+		strings.Join(batch, " OR "),
+		"-repo:" + pkg.repoString(),
+	}
+	if flags.lang == "" {
+		terms = append(terms, "language:go")
+	}
+	query := makeQuery(terms)
+	if flags.showQuery {
+		fmt.Println(query)
+		return nil, false, nil
+	}
+	v("Query: %s", query)
+
+	res, total, err := performCountedSearch(ctx, query, flags.limit)
+	if err != nil {
+		return nil, false, err
+	}
+	searchResult := SearchResult{}
+	for key, tms := range coerceResults(res) {
+		// Negative qualifiers are best effort on some GitHub versions
+		if key.RepoString() != pkg.repoString() {
+			searchResult[key] = tms
+		}
+	}
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return nil, false, err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return nil, false, err
+	}
+	selectors := collectSelectors(fullText, pkg.importPath)
+
+	found := map[string][]FileKey{}
+	for _, sym := range batch {
+		keys := []FileKey{}
+		for key := range selectors[sym] {
+			keys = append(keys, key)
+		}
+		sort.Sort(FileKeys(keys))
+		found[sym] = keys
+	}
+	return found, len(res) < total, nil
+}
+
+func printUnused(exports []goExport, refs map[string][]FileKey) {
+	unused := []goExport{}
+	for _, e := range exports {
+		if len(refs[e.name]) <= unusedFlags.maxRefs {
+			unused = append(unused, e)
+		}
+	}
+	sort.SliceStable(unused, func(i, j int) bool {
+		return len(refs[unused[i].name]) < len(refs[unused[j].name])
+	})
+
+	rows := [][]string{}
+	for _, e := range unused {
+		files := []string{}
+		for _, key := range refs[e.name] {
+			u := makeGithubSiteURL(fmt.Sprintf("%s/blob/HEAD/%s", key.RepoString(), key.Path))
+			files = append(files, color.BlueString(ansiURL(key.String(), u)))
+		}
+		rows = append(rows, []string{e.name, e.kind, fmt.Sprint(len(refs[e.name])), strings.Join(files, ", ")})
+	}
+	writeRows("table", []string{"SYMBOL", "KIND", "REFS", "FILES"}, rows)
+
+	fmt.Fprintf(
+		os.Stderr,
+		"%d of %d exported symbols are referenced by at most %d files elsewhere\n",
+		len(unused), len(exports), unusedFlags.maxRefs,
+	)
+}
@@ -0,0 +1,242 @@
+// Symbol usage statistics
+//
+// Deprecating part of a package starts with knowing how much of it is used,
+// and by whom. 'cs usage' breaks that down per exported symbol.
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	gotoken "go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage importpath",
+	Short: "Count the repos and call sites using each exported symbol of a Go package",
+	Long: `
+Count the repos and call sites using each exported symbol of a Go package
+
+Files importing the package are searched for, fetched, and parsed so that only
+real references to the package count. When the package lives on the GitHub
+instance we talk to, its source is fetched too so symbols nobody uses are
+listed with zero.
+
+Only as many files as --limit allows are looked at. Raise it for a complete
+picture.
+
+	cs usage github.com/coxley/codesearch/cs --limit 500
+	cs usage github.com/coxley/codesearch/cs --format csv
+	cs usage github.com/coxley/codesearch/cs --symbol FileKey
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeUsage,
+}
+
+var usageFlags = struct {
+	format string
+	symbol string
+}{}
+
+func init() {
+	usageCmd.Flags().StringVar(&usageFlags.format, "format", "table", "output as table, json, or csv")
+	usageCmd.Flags().StringVar(&usageFlags.symbol, "symbol", "", "show every site using [symbol] instead of the summary")
+	rootCmd.AddCommand(usageCmd)
+}
+
+type symbolUsage struct {
+	Symbol string      `json:"symbol"`
+	Kind   string      `json:"kind,omitempty"`
+	Repos  int         `json:"repos"`
+	Sites  int         `json:"sites"`
+	Uses   []usageSite `json:"uses,omitempty"`
+}
+
+type usageSite struct {
+	Repo string `json:"repo"`
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	URL  string `json:"url"`
+}
+
+func executeUsage(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	ctx := cmd.Context()
+	importPath := args[0]
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return err
+	}
+
+	terms := []string{strconv.Quote(importPath)}
+	if flags.lang == "" {
+		terms = append(terms, "language:go")
+	}
+	query := makeQuery(terms)
+	if flags.showQuery {
+		fmt.Println(query)
+		return nil
+	}
+	v("Query: %s", query)
+
+	// Knowing the exports lets us list what isn't used at all
+	exports := []goExport{}
+	if owner, name, dir, err := repoForImportPath(importPath); err != nil {
+		w("%v: only symbols in use will be listed", err)
+	} else if pkg, err := loadGoPackage(httpClient, owner, name, dir); err != nil {
+		w("couldn't load %s: %v: only symbols in use will be listed", importPath, err)
+	} else {
+		exports = pkg.exports()
+	}
+
+	res, total, err := performCountedSearch(ctx, query, flags.limit)
+	if err != nil {
+		return err
+	}
+	warnIfCapped("", len(res), total)
+	searchResult := coerceResults(res)
+
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return err
+	}
+	selectors := collectSelectors(fullText, importPath)
+
+	// Drilling down is just 'cs refs' without searching again
+	if usageFlags.symbol != "" {
+		result := SearchResult{}
+		for key, indices := range selectors[usageFlags.symbol] {
+			result[key] = []TextMatch{{Fragment: fullText.Values[key], Indices: indices}}
+		}
+		flags.showFunction = true
+		printMatches(createMatches(result, fullText, defaultBranches))
+		return nil
+	}
+
+	usage := summarizeUsage(exports, selectors, fullText, defaultBranches)
+	switch usageFlags.format {
+	case "json":
+		err = writeJSON(usage)
+	default:
+		rows := [][]string{}
+		for _, u := range usage {
+			rows = append(rows, []string{u.Symbol, u.Kind, fmt.Sprint(u.Repos), fmt.Sprint(u.Sites)})
+		}
+		err = writeRows(usageFlags.format, []string{"SYMBOL", "KIND", "REPOS", "SITES"}, rows)
+	}
+	return err
+}
+
+// collectSelectors parses every fetched Go file for references to the
+// package, keyed by symbol then file
+func collectSelectors(fullText FullText, importPath string) map[string]map[FileKey][][2]int {
+	selectors := map[string]map[FileKey][][2]int{}
+	for key, content := range fullText.Values {
+		if !strings.HasSuffix(key.Path, ".go") {
+			continue
+		}
+
+		fset := gotoken.NewFileSet()
+		f, err := parser.ParseFile(fset, key.Path, content, 0)
+		if err != nil {
+			v("couldn't parse %s: %v", key.String(), err)
+			continue
+		}
+
+		for sym, indices := range goSelectors(fset, f, importPath) {
+			if selectors[sym] == nil {
+				selectors[sym] = map[FileKey][][2]int{}
+			}
+			selectors[sym][key] = indices
+		}
+	}
+	return selectors
+}
+
+// summarizeUsage counts repos and sites per symbol, most used first
+//
+// Symbols referenced but missing from exports are still listed. Either we
+// couldn't load the package or it's an older version than what's in use.
+func summarizeUsage(
+	exports []goExport,
+	selectors map[string]map[FileKey][][2]int,
+	fullText FullText,
+	defaultBranches map[string]string,
+) []symbolUsage {
+	kinds := map[string]string{}
+	for _, e := range exports {
+		kinds[e.name] = e.kind
+	}
+
+	symbols := []string{}
+	for _, e := range exports {
+		symbols = append(symbols, e.name)
+	}
+	for sym := range selectors {
+		if _, ok := kinds[sym]; !ok && gotoken.IsExported(sym) {
+			symbols = append(symbols, sym)
+		}
+	}
+
+	usage := []symbolUsage{}
+	for _, sym := range symbols {
+		u := symbolUsage{Symbol: sym, Kind: kinds[sym], Uses: []usageSite{}}
+		repos := map[string]struct{}{}
+		for key, indices := range selectors[sym] {
+			repos[key.RepoString()] = struct{}{}
+			content := fullText.Values[key]
+			for _, idx := range indices {
+				m := match{
+					owner:  key.Owner,
+					repo:   key.Name,
+					branch: defaultBranches[key.RepoString()],
+					path:   key.Path,
+					lineno: strings.Count(content[:idx[0]], "\n") + 1,
+				}
+				u.Uses = append(u.Uses, usageSite{
+					Repo: m.repoString(),
+					Path: m.path,
+					Line: m.lineno,
+					URL:  m.lineURL(),
+				})
+			}
+		}
+		u.Repos = len(repos)
+		u.Sites = len(u.Uses)
+
+		sort.Slice(u.Uses, func(i, j int) bool {
+			ui, uj := u.Uses[i], u.Uses[j]
+			if ui.Repo != uj.Repo {
+				return ui.Repo < uj.Repo
+			}
+			if ui.Path != uj.Path {
+				return ui.Path < uj.Path
+			}
+			return ui.Line < uj.Line
+		})
+		usage = append(usage, u)
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		ui, uj := usage[i], usage[j]
+		if ui.Repos != uj.Repos {
+			return ui.Repos > uj.Repos
+		}
+		if ui.Sites != uj.Sites {
+			return ui.Sites > uj.Sites
+		}
+		return ui.Symbol < uj.Symbol
+	})
+	return usage
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSummarizeUsage(t *testing.T) {
+	a := FileKey{Owner: "o", Name: "a", Path: "main.go"}
+	b := FileKey{Owner: "o", Name: "b", Path: "main.go"}
+	fullText := FullText{Values: map[FileKey]string{
+		a: "package main\n\nimport \"example.com/lib\"\n\nfunc main() {\n\tlib.Foo()\n\tlib.Bar()\n\tlib.Bar()\n}\n",
+		b: "package main\n\nimport l \"example.com/lib\"\n\nvar x l.Bar\n",
+	}}
+	exports := []goExport{{"Bar", "func"}, {"Foo", "func"}, {"Unused", "type"}}
+
+	usage := summarizeUsage(exports, collectSelectors(fullText, "example.com/lib"), fullText, map[string]string{})
+
+	expected := []struct {
+		symbol       string
+		repos, sites int
+	}{{"Bar", 2, 3}, {"Foo", 1, 1}, {"Unused", 0, 0}}
+	if len(usage) != len(expected) {
+		t.Fatalf("expected %d symbols, got: %+v", len(expected), usage)
+	}
+	for i, e := range expected {
+		u := usage[i]
+		if u.Symbol != e.symbol || u.Repos != e.repos || u.Sites != e.sites {
+			t.Errorf("expected: %+v, got: %+v", e, u)
+		}
+	}
+
+	if line := usage[1].Uses[0].Line; line != 6 {
+		t.Errorf("expected Foo to be used on line 6, got: %d", line)
+	}
+}
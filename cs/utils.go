@@ -28,25 +28,33 @@ func w(format string, a ...any) {
 	fmt.Fprintln(os.Stderr)
 }
 
-func fatalf(format string, a ...any) {
-	fmt.Fprintf(os.Stderr, format, a...)
-	os.Exit(1)
-}
-
-func getAuthenticatedHTTP(ctx context.Context) *http.Client {
+func getAuthenticatedHTTP(ctx context.Context) (*http.Client, error) {
 	if token == "" {
-		fatalf("please run %s set-token", os.Args[0])
+		return nil, &apiError{kind: failureAuth, message: fmt.Sprintf("no token: please run %s set-token", os.Args[0])}
 	}
 
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
-	return oauth2.NewClient(ctx, ts)
+	client := oauth2.NewClient(ctx, ts)
+	client.Transport = &retryTransport{
+		base: &rateLimitTransport{base: client.Transport, limiter: scheduler},
+	}
+	if !flags.noCache {
+		if cache := newCacheTransport(client.Transport, token); cache != nil {
+			client.Transport = cache
+		}
+	}
+	return client, nil
 }
 
 func githubClient(ctx context.Context) (*github.Client, error) {
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return nil, err
+	}
 	baseURL := viper.GetString("base_url")
-	return github.NewEnterpriseClient(baseURL, baseURL, getAuthenticatedHTTP(ctx))
+	return github.NewEnterpriseClient(baseURL, baseURL, httpClient)
 }
 
 // Create non-API links (repos, files)
@@ -0,0 +1,467 @@
+// Saved searches
+//
+// Some searches are worth running forever: new uses of a banned API, secrets
+// that shouldn't be committed, etc. 'cs watch' saves them along with the
+// matching lines it last saw so each run only reports what changed.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Save searches and report matches that appear or disappear",
+	Long: `
+Save searches and report matches that appear or disappear
+
+Everything after '--' is what you'd pass to 'cs' itself. Matches are compared
+by repo, path, and line content, so edits elsewhere in a file don't show up as
+changes.
+
+'cs watch run' exits with status 1 when any watch has new matches, so it can
+gate cron jobs and CI.
+
+	cs watch add old-client -- OldClient --lang go -o myorg
+	cs watch run
+	cs watch run old-client --format json
+	cs watch list
+	cs watch rm old-client
+	`,
+}
+
+var watchAddCmd = &cobra.Command{
+	Use:   "add NAME -- [terms] [flags]",
+	Short: "Save a search and record what it matches now",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  executeWatchAdd,
+}
+
+var watchRunCmd = &cobra.Command{
+	Use:   "run [NAME...]",
+	Short: "Re-run saved searches and report new and removed matches",
+	RunE:  executeWatchRun,
+}
+
+var watchListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved searches",
+	Args:  cobra.NoArgs,
+	RunE:  executeWatchList,
+}
+
+var watchRmCmd = &cobra.Command{
+	Use:   "rm NAME...",
+	Short: "Remove saved searches",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  executeWatchRm,
+}
+
+var watchFlags = struct {
+	format string
+}{}
+
+func init() {
+	watchRunCmd.Flags().StringVar(&watchFlags.format, "format", "text", "output as text or json")
+	watchCmd.AddCommand(watchAddCmd, watchRunCmd, watchListCmd, watchRmCmd)
+	rootCmd.AddCommand(watchCmd)
+}
+
+// errNewMatches fails 'cs watch run' so cron jobs and CI notice
+const errNewMatches = exitStatus(1)
+
+type savedSearch struct {
+	Name     string         `json:"name"`
+	Args     []string       `json:"args"`
+	Query    string         `json:"query"`
+	Limit    int            `json:"limit"`
+	Created  time.Time      `json:"created"`
+	Snapshot *watchSnapshot `json:"snapshot,omitempty"`
+}
+
+type watchSnapshot struct {
+	Taken   time.Time    `json:"taken"`
+	Matches []watchMatch `json:"matches"`
+}
+
+// watchMatch is a matching line, without highlighting
+type watchMatch struct {
+	Repo string `json:"repo"`
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	URL  string `json:"url,omitempty"`
+}
+
+type watchReport struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// Nothing to compare against on the first run
+	Baseline bool         `json:"baseline"`
+	Since    time.Time    `json:"since"`
+	Added    []watchMatch `json:"added"`
+	Removed  []watchMatch `json:"removed"`
+}
+
+func executeWatchAdd(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	if cmd.ArgsLenAtDash() != 1 {
+		return errors.New("usage: cs watch add NAME -- [terms] [flags]")
+	}
+	name := args[0]
+	path, err := storePath("watches", name)
+	if err != nil {
+		return err
+	}
+	if err := loadJSON(path, &savedSearch{}); !errors.Is(err, errNotStored) {
+		return fmt.Errorf("%s already exists: remove it first with 'cs watch rm %s'", name, name)
+	}
+
+	// Parse the search the same way 'cs' would
+	if err := rootCmd.ParseFlags(args[1:]); err != nil {
+		return err
+	}
+	terms := rootCmd.Flags().Args()
+	if len(terms) == 0 {
+		return errors.New("a saved search needs search terms")
+	}
+	if flags.count || flags.onlyFiles || flags.onlyRepos || flags.onlyFullNames {
+		return errors.New("output flags like --count and --files-only don't apply to saved searches")
+	}
+
+	s := savedSearch{
+		Name:    name,
+		Args:    args[1:],
+		Query:   makeQuery(terms),
+		Limit:   flags.limit,
+		Created: time.Now(),
+	}
+	if flags.showQuery {
+		fmt.Println(s.Query)
+		return nil
+	}
+	v("Query: %s", s.Query)
+
+	run, err := runSavedSearch(cmd.Context(), s)
+	if err != nil {
+		return err
+	}
+	s.Snapshot = &watchSnapshot{Taken: time.Now(), Matches: run.matches}
+	if err := saveJSON(path, s); err != nil {
+		return fmt.Errorf("couldn't save %s: %w", name, err)
+	}
+	fmt.Printf("Saved %s with %d matches\n", name, len(run.matches))
+	return nil
+}
+
+func executeWatchRun(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	if watchFlags.format != "text" && watchFlags.format != "json" {
+		return fmt.Errorf("unknown format: %s", watchFlags.format)
+	}
+
+	names := args
+	if len(names) == 0 {
+		var err error
+		if names, err = storeNames("watches"); err != nil {
+			return err
+		}
+	}
+
+	reports := []watchReport{}
+	for _, name := range names {
+		path, err := storePath("watches", name)
+		if err != nil {
+			return err
+		}
+		var s savedSearch
+		if err := loadJSON(path, &s); errors.Is(err, errNotStored) {
+			return fmt.Errorf("no saved search named %s", name)
+		} else if err != nil {
+			return err
+		}
+		v("Query: %s", s.Query)
+
+		run, err := runSavedSearch(cmd.Context(), s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		report := watchReport{Name: s.Name, Query: s.Query, Baseline: s.Snapshot == nil}
+		// Matches that weren't seen stay in the snapshot until a run can tell
+		matches := run.matches
+		if s.Snapshot != nil {
+			var unseen []watchMatch
+			report.Since = s.Snapshot.Taken
+			report.Added, report.Removed = diffWatchMatches(s.Snapshot.Matches, run.matches)
+			report.Removed, unseen = run.confirmRemoved(report.Removed)
+			matches = append(unseen, matches...)
+			sortWatchMatches(matches)
+		} else {
+			report.Added, report.Removed = []watchMatch{}, []watchMatch{}
+		}
+		reports = append(reports, report)
+
+		s.Snapshot = &watchSnapshot{Taken: time.Now(), Matches: matches}
+		if err := saveJSON(path, s); err != nil {
+			return fmt.Errorf("couldn't save %s: %w", name, err)
+		}
+	}
+
+	if watchFlags.format == "json" {
+		if err := writeJSON(reports); err != nil {
+			return err
+		}
+	} else {
+		printWatchReports(reports)
+	}
+
+	for _, r := range reports {
+		if len(r.Added) > 0 {
+			return errNewMatches
+		}
+	}
+	return nil
+}
+
+func executeWatchList(cmd *cobra.Command, args []string) error {
+	names, err := storeNames("watches")
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, name := range names {
+		path, err := storePath("watches", name)
+		if err != nil {
+			return err
+		}
+		var s savedSearch
+		if err := loadJSON(path, &s); err != nil {
+			return err
+		}
+		matches, taken := "", ""
+		if s.Snapshot != nil {
+			matches = fmt.Sprint(len(s.Snapshot.Matches))
+			taken = s.Snapshot.Taken.Format("2006-01-02 15:04")
+		}
+		rows = append(rows, []string{s.Name, matches, taken, strings.Join(s.Args, " ")})
+	}
+	return writeRows("table", []string{"NAME", "MATCHES", "LAST RUN", "SEARCH"}, rows)
+}
+
+func executeWatchRm(cmd *cobra.Command, args []string) error {
+	for _, name := range args {
+		path, err := storePath("watches", name)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no saved search named %s", name)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savedRun is what a saved search matched, and whether that was everything
+type savedRun struct {
+	name    string
+	matches []watchMatch
+	// Files GitHub counted, which can be more than were looked at
+	total int
+	// Files that matched but whose lines can't be compared: they couldn't be
+	// fetched, were too big to fetch in full, or are binary
+	failed map[string]bool
+	// --limit or GitHub's cap left matches out
+	capped bool
+}
+
+// runSavedSearch and return the matching lines, sorted
+func runSavedSearch(ctx context.Context, s savedSearch) (savedRun, error) {
+	res, total, err := performCountedSearch(ctx, s.Query, s.Limit)
+	if err != nil {
+		return savedRun{}, err
+	}
+	warnIfCapped(s.Name, len(res), total)
+	searchResult := coerceResults(res)
+
+	httpClient, err := getAuthenticatedHTTP(ctx)
+	if err != nil {
+		return savedRun{}, err
+	}
+	defaultBranches, err := getDefaultBranches(httpClient, searchResult)
+	if err != nil {
+		return savedRun{}, err
+	}
+	fullText, err := fetchFullText(httpClient, searchResult, defaultBranches)
+	if err != nil {
+		return savedRun{}, err
+	}
+
+	return newSavedRun(s.Name, searchResult, fullText, defaultBranches, total), nil
+}
+
+// newSavedRun from fetched results, of total files GitHub counted
+func newSavedRun(name string, searchResult SearchResult, fullText FullText, defaultBranches map[string]string, total int) savedRun {
+	// --limit already capped the files, every line in them counts
+	run := savedRun{
+		name:    name,
+		matches: plainMatches(searchResult, fullText, defaultBranches),
+		total:   total,
+		failed:  map[string]bool{},
+		capped:  total > len(searchResult),
+	}
+	for _, keys := range []map[FileKey]bool{fullText.Truncated, fullText.Binary} {
+		for key := range keys {
+			run.failed[key.String()] = true
+		}
+	}
+	for key := range fullText.Failed {
+		run.failed[key.String()] = true
+	}
+	return run
+}
+
+// confirmRemoved splits matches a run didn't find into ones it would have
+// found if they were still there, and ones it couldn't have seen
+//
+// When results were capped, anything could be past the cap. Otherwise only
+// files that couldn't be fetched are in doubt.
+func (r savedRun) confirmRemoved(removed []watchMatch) (gone, unseen []watchMatch) {
+	if r.capped {
+		if len(removed) > 0 {
+			w("%s: results were capped, so %d matches that weren't seen aren't reported as removed", r.name, len(removed))
+		}
+		return []watchMatch{}, removed
+	}
+
+	gone, unseen = []watchMatch{}, []watchMatch{}
+	for _, m := range removed {
+		if r.failed[m.file()] {
+			unseen = append(unseen, m)
+			continue
+		}
+		gone = append(gone, m)
+	}
+	if len(unseen) > 0 {
+		w("%s: %d matches are in files that couldn't be read line by line: not reporting them as removed", r.name, len(unseen))
+	}
+	return gone, unseen
+}
+
+// plainMatches are every matching line of results, sorted and without
+// highlighting or context
+//
+// Binary files have no lines, and excerpts of big files have no line numbers
+// to link to, so neither is included.
+func plainMatches(searchResult SearchResult, fullText FullText, defaultBranches map[string]string) []watchMatch {
+	// Highlighting is for terminals, not for comparing
+	matches, _ := createMatchesWith(searchResult, fullText, defaultBranches, 0, matchOptions{})
+
+	found := []watchMatch{}
+	for _, m := range matches {
+		if m.lineno != m.matchLine || m.binary || m.excerpt {
+			continue
+		}
+		found = append(found, watchMatch{Repo: m.repoString(), Path: m.path, Line: m.lineno, Text: m.text, URL: m.lineURL()})
+	}
+	sortWatchMatches(found)
+	return found
+}
+
+// diffWatchMatches by repo, path, and line content
+//
+// Line numbers are left out so unrelated edits above a match don't count as
+// a change. Identical lines in the same file are counted, not collapsed.
+func diffWatchMatches(prev, cur []watchMatch) (added, removed []watchMatch) {
+	counts := map[string]int{}
+	for _, m := range prev {
+		counts[m.key()]++
+	}
+
+	added = []watchMatch{}
+	for _, m := range cur {
+		if counts[m.key()] > 0 {
+			counts[m.key()]--
+			continue
+		}
+		added = append(added, m)
+	}
+
+	removed = []watchMatch{}
+	for _, m := range prev {
+		if counts[m.key()] > 0 {
+			counts[m.key()]--
+			removed = append(removed, m)
+		}
+	}
+	sortWatchMatches(added)
+	sortWatchMatches(removed)
+	return added, removed
+}
+
+// file the match is in, like FileKey.String
+func (m watchMatch) file() string {
+	return m.Repo + " " + m.Path
+}
+
+// key identifies a match across runs, even if its line number changes
+func (m watchMatch) key() string {
+	return m.Repo + "\x00" + m.Path + "\x00" + strings.TrimSpace(m.Text)
+}
+
+func sortWatchMatches(matches []watchMatch) {
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+}
+
+func printWatchReports(reports []watchReport) {
+	for i, r := range reports {
+		if i > 0 {
+			fmt.Println()
+		}
+		header := color.New(color.Bold).Sprint(r.Name)
+		if r.Baseline {
+			fmt.Printf("%s: recorded a baseline\n", header)
+			continue
+		}
+		fmt.Printf("%s: %d new, %d gone since %s\n", header, len(r.Added), len(r.Removed), r.Since.Format("2006-01-02 15:04"))
+		for _, m := range r.Added {
+			fmt.Println(color.GreenString("+"), formatWatchMatch(m))
+		}
+		for _, m := range r.Removed {
+			fmt.Println(color.RedString("-"), formatWatchMatch(m))
+		}
+	}
+}
+
+func formatWatchMatch(m watchMatch) string {
+	return fmt.Sprintf("%s:%s:%s: %s",
+		color.BlueString(m.Repo),
+		color.BlueString(m.Path),
+		color.GreenString(fmt.Sprint(m.Line)),
+		strings.TrimSpace(m.Text),
+	)
+}
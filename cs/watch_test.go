@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+	"golang.org/x/exp/slices"
+)
+
+func TestDiffWatchMatches(t *testing.T) {
+	prev := []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 10, Text: "OldClient()"},
+		{Repo: "a/x", Path: "main.go", Line: 20, Text: "OldClient()"},
+		{Repo: "a/y", Path: "lib.go", Line: 3, Text: "\tOldClient{}"},
+	}
+	cur := []watchMatch{
+		// Moved down a few lines and reindented: not a change
+		{Repo: "a/x", Path: "main.go", Line: 14, Text: "  OldClient()"},
+		{Repo: "a/y", Path: "new.go", Line: 1, Text: "OldClient{}"},
+	}
+
+	added, removed := diffWatchMatches(prev, cur)
+	lines := func(ms []watchMatch) []int {
+		got := []int{}
+		for _, m := range ms {
+			got = append(got, m.Line)
+		}
+		return got
+	}
+	if got := lines(added); !slices.Equal(got, []int{1}) || added[0].Path != "new.go" {
+		t.Errorf("expected new.go:1 to be added, got: %+v", added)
+	}
+	// Either OldClient() in main.go could be the one that's gone
+	if len(removed) != 2 || removed[0].Path != "main.go" || removed[1].Path != "lib.go" {
+		t.Errorf("expected one of main.go and lib.go to be removed, got: %+v", removed)
+	}
+
+	added, removed = diffWatchMatches(cur, cur)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("expected no changes, got: +%v -%v", added, removed)
+	}
+}
+
+func TestConfirmRemoved(t *testing.T) {
+	removed := []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 10, Text: "OldClient()"},
+		{Repo: "a/y", Path: "lib.go", Line: 3, Text: "OldClient{}"},
+	}
+
+	run := savedRun{failed: map[string]bool{"a/y lib.go": true}}
+	gone, unseen := run.confirmRemoved(removed)
+	if len(gone) != 1 || gone[0].Path != "main.go" || len(unseen) != 1 || unseen[0].Path != "lib.go" {
+		t.Errorf("expected only matches in fetched files to be gone, got: %+v and %+v", gone, unseen)
+	}
+
+	run = savedRun{capped: true, failed: map[string]bool{}}
+	gone, unseen = run.confirmRemoved(removed)
+	if len(gone) != 0 || len(unseen) != len(removed) {
+		t.Errorf("expected nothing to be gone from capped results, got: %+v", gone)
+	}
+}
+
+func TestRunSavedSearchCapped(t *testing.T) {
+	srv := fakeSearchServer(t, 60)
+	prevURL, prevBranch, prevToken := viper.Get("base_url"), viper.Get("defaultBranch"), token
+	viper.Set("base_url", srv)
+	viper.Set("defaultBranch", "main")
+	viper.Set("data_dir", t.TempDir())
+	token = "test"
+	defer func() {
+		viper.Set("base_url", prevURL)
+		viper.Set("defaultBranch", prevBranch)
+		viper.Set("data_dir", nil)
+		token = prevToken
+	}()
+
+	run, err := runSavedSearch(context.Background(), savedSearch{Name: "capped", Query: "f", Limit: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.capped || run.total != 60 || len(run.matches) != 30 {
+		t.Errorf("expected 30 of 60 files and capped results, got: %d of %d (capped: %v)", len(run.matches), run.total, run.capped)
+	}
+
+	run, err = runSavedSearch(context.Background(), savedSearch{Name: "all", Query: "f", Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.capped || len(run.failed) != 0 || len(run.matches) != 60 {
+		t.Errorf("expected all 60 files, got: %d (capped: %v, failed: %v)", len(run.matches), run.capped, run.failed)
+	}
+}
+
+func TestPlainMatchesIgnoreFlags(t *testing.T) {
+	flags.context, color.NoColor = 2, false
+	defer func() { flags.context, color.NoColor = 0, true }()
+
+	key := FileKey{Owner: "coxley", Name: "codesearch", Path: "main.go"}
+	searchResult := SearchResult{key: {{Fragment: "OldClient()", Indices: [][2]int{{0, 9}}}}}
+	fullText := FullText{Values: map[FileKey]string{key: "package main\n\nfunc main() {\n\tOldClient()\n}\n"}}
+
+	found := plainMatches(searchResult, fullText, map[string]string{"coxley/codesearch": "main"})
+	if len(found) != 1 || found[0].Line != 4 || strings.TrimSpace(found[0].Text) != "OldClient()" {
+		t.Errorf("expected only the matching line, uncolored, got: %+v", found)
+	}
+	if flags.context != 2 || color.NoColor {
+		t.Errorf("expected flags to be left alone, got: -C %d, NoColor %v", flags.context, color.NoColor)
+	}
+}
+
+func TestStore(t *testing.T) {
+	viper.Set("data_dir", t.TempDir())
+	defer viper.Set("data_dir", nil)
+
+	for _, name := range []string{"", "../escape", ".hidden", "a b"} {
+		if _, err := storePath("watches", name); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+
+	path, err := storePath("watches", "old-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadJSON(path, &savedSearch{}); !errors.Is(err, errNotStored) {
+		t.Errorf("expected errNotStored before saving, got: %v", err)
+	}
+
+	saved := savedSearch{Name: "old-client", Args: []string{"OldClient", "--lang", "go"}, Limit: 30}
+	if err := saveJSON(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	var loaded savedSearch
+	if err := loadJSON(path, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Name != saved.Name || loaded.Limit != saved.Limit || !slices.Equal(loaded.Args, saved.Args) {
+		t.Errorf("expected: %+v, got: %+v", saved, loaded)
+	}
+
+	names, err := storeNames("watches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"old-client"}) {
+		t.Errorf("expected [old-client], got: %v", names)
+	}
+}
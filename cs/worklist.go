@@ -0,0 +1,459 @@
+// Worklists
+//
+// Large cleanups get split across people. A worklist turns every match of a
+// search into an item someone can claim, skip, or leave notes on. Syncing
+// re-runs the search: fixed items close themselves and new ones are added.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var worklistCmd = &cobra.Command{
+	Use:   "worklist",
+	Short: "Track every match of a search as a work item",
+	Long: `
+Track every match of a search as a work item
+
+Items are open until someone claims or skips them, and close on their own once
+a sync no longer finds them. A sync that hits --limit closes nothing, so make
+it large enough to see everything. Items are matched across syncs by repo,
+path, and line content, so they survive unrelated edits.
+
+	cs worklist create old-client OldClient --lang go --limit 500
+	cs worklist claim old-client 3 4 5 --by alice
+	cs worklist skip old-client 7 --note "generated code"
+	cs worklist note old-client 3 "PR is up"
+	cs worklist sync old-client
+	cs worklist show old-client --status open
+	cs worklist show old-client --format markdown > cleanup.md
+	`,
+}
+
+var worklistCreateCmd = &cobra.Command{
+	Use:   "create NAME [terms]",
+	Short: "Create a worklist from every match of a search",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  executeWorklistCreate,
+}
+
+var worklistSyncCmd = &cobra.Command{
+	Use:   "sync NAME",
+	Short: "Re-run the search, closing items that are gone and adding new ones",
+	Args:  cobra.ExactArgs(1),
+	RunE:  executeWorklistSync,
+}
+
+var worklistClaimCmd = &cobra.Command{
+	Use:   "claim NAME ID...",
+	Short: "Claim items",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		by := worklistFlags.by
+		if by == "" {
+			by = os.Getenv("USER")
+		}
+		return updateWorkItems(args[0], args[1:], worklistFlags.note, func(item *workItem) {
+			item.Status = statusClaimed
+			item.ClaimedBy = by
+		})
+	},
+}
+
+var worklistSkipCmd = &cobra.Command{
+	Use:   "skip NAME ID...",
+	Short: "Skip items that won't be worked on",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateWorkItems(args[0], args[1:], worklistFlags.note, func(item *workItem) {
+			item.Status = statusSkipped
+		})
+	},
+}
+
+var worklistOpenCmd = &cobra.Command{
+	Use:   "open NAME ID...",
+	Short: "Reopen claimed or skipped items",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateWorkItems(args[0], args[1:], worklistFlags.note, func(item *workItem) {
+			item.Status = statusOpen
+			item.ClaimedBy = ""
+		})
+	},
+}
+
+var worklistNoteCmd = &cobra.Command{
+	Use:   "note NAME ID TEXT",
+	Short: "Leave a note on an item",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateWorkItems(args[0], args[1:2], args[2], func(item *workItem) {})
+	},
+}
+
+var worklistShowCmd = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show items as a table, Markdown checklist, or CSV",
+	Args:  cobra.ExactArgs(1),
+	RunE:  executeWorklistShow,
+}
+
+var worklistFlags = struct {
+	by     string
+	note   string
+	format string
+	status string
+}{}
+
+func init() {
+	worklistClaimCmd.Flags().StringVar(&worklistFlags.by, "by", "", "who is claiming, defaulting to $USER")
+	for _, cmd := range []*cobra.Command{worklistClaimCmd, worklistSkipCmd, worklistOpenCmd} {
+		cmd.Flags().StringVar(&worklistFlags.note, "note", "", "leave a note on the items too")
+	}
+	worklistShowCmd.Flags().StringVar(&worklistFlags.format, "format", "table", "output as table, markdown, or csv")
+	worklistShowCmd.Flags().StringVar(&worklistFlags.status, "status", "", "only show items that are open, claimed, skipped, or done")
+
+	worklistCmd.AddCommand(
+		worklistCreateCmd,
+		worklistSyncCmd,
+		worklistClaimCmd,
+		worklistSkipCmd,
+		worklistOpenCmd,
+		worklistNoteCmd,
+		worklistShowCmd,
+	)
+	rootCmd.AddCommand(worklistCmd)
+}
+
+const (
+	statusOpen    = "open"
+	statusClaimed = "claimed"
+	statusSkipped = "skipped"
+	statusDone    = "done"
+)
+
+type worklist struct {
+	Name    string     `json:"name"`
+	Query   string     `json:"query"`
+	Limit   int        `json:"limit"`
+	Created time.Time  `json:"created"`
+	Synced  time.Time  `json:"synced"`
+	NextID  int        `json:"next_id"`
+	Items   []workItem `json:"items"`
+}
+
+type workItem struct {
+	ID        int        `json:"id"`
+	Status    string     `json:"status"`
+	ClaimedBy string     `json:"claimed_by,omitempty"`
+	Notes     []workNote `json:"notes,omitempty"`
+	Added     time.Time  `json:"added"`
+	Closed    time.Time  `json:"closed,omitempty"`
+	watchMatch
+}
+
+type workNote struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+func executeWorklistCreate(cmd *cobra.Command, args []string) error {
+	name, terms := args[0], args[1:]
+	path, err := storePath("worklists", name)
+	if err != nil {
+		return err
+	}
+	if err := loadJSON(path, &worklist{}); !errors.Is(err, errNotStored) {
+		return fmt.Errorf("%s already exists", name)
+	}
+
+	wl := worklist{Name: name, Query: makeQuery(terms), Limit: flags.limit, Created: time.Now(), NextID: 1}
+	if flags.showQuery {
+		fmt.Println(wl.Query)
+		return nil
+	}
+	v("Query: %s", wl.Query)
+
+	run, err := runSavedSearch(cmd.Context(), savedSearch{Name: name, Query: wl.Query, Limit: wl.Limit})
+	if err != nil {
+		return err
+	}
+	added, _, _ := wl.sync(run, time.Now())
+	if err := saveJSON(path, wl); err != nil {
+		return fmt.Errorf("couldn't save %s: %w", name, err)
+	}
+	fmt.Printf("Created %s with %d items\n", name, added)
+	return nil
+}
+
+func executeWorklistSync(cmd *cobra.Command, args []string) error {
+	path, wl, err := loadWorklist(args[0])
+	if err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("limit") {
+		wl.Limit = flags.limit
+	}
+	v("Query: %s", wl.Query)
+
+	run, err := runSavedSearch(cmd.Context(), savedSearch{Name: wl.Name, Query: wl.Query, Limit: wl.Limit})
+	if err != nil {
+		return err
+	}
+	added, closed, unseen := wl.sync(run, time.Now())
+	if err := saveJSON(path, wl); err != nil {
+		return fmt.Errorf("couldn't save %s: %w", wl.Name, err)
+	}
+
+	if len(unseen) > 0 {
+		why := "their files couldn't be read line by line"
+		if run.capped {
+			why = "results were capped"
+		}
+		w("%s: not closing %d items the search couldn't see: %s", wl.Name, len(unseen), why)
+		for _, item := range unseen {
+			fmt.Fprintf(os.Stderr, "  %d %s\n", item.ID, formatWatchMatch(item.watchMatch))
+		}
+	}
+
+	counts := wl.counts()
+	fmt.Printf("%s: %d added, %d closed; %d open, %d claimed, %d skipped, %d done\n",
+		wl.Name, added, closed, counts[statusOpen], counts[statusClaimed], counts[statusSkipped], counts[statusDone])
+	return nil
+}
+
+func executeWorklistShow(cmd *cobra.Command, args []string) error {
+	if flags.forceColor {
+		color.NoColor = false
+	}
+	_, wl, err := loadWorklist(args[0])
+	if err != nil {
+		return err
+	}
+
+	items := []workItem{}
+	for _, item := range wl.Items {
+		if worklistFlags.status == "" || item.Status == worklistFlags.status {
+			items = append(items, item)
+		}
+	}
+
+	switch worklistFlags.format {
+	case "markdown":
+		fmt.Print(worklistMarkdown(wl, items))
+	case "csv":
+		rows := [][]string{}
+		for _, item := range items {
+			notes := []string{}
+			for _, n := range item.Notes {
+				notes = append(notes, n.Text)
+			}
+			rows = append(rows, []string{
+				fmt.Sprint(item.ID), item.Status, item.ClaimedBy, item.Repo, item.Path,
+				fmt.Sprint(item.Line), item.URL, strings.TrimSpace(item.Text), strings.Join(notes, "; "),
+			})
+		}
+		header := []string{"id", "status", "claimed_by", "repo", "path", "line", "url", "text", "notes"}
+		if err := writeRows("csv", header, rows); err != nil {
+			return err
+		}
+	case "table":
+		statusColors := map[string]color.Attribute{
+			statusOpen:    color.FgYellow,
+			statusClaimed: color.FgCyan,
+			statusSkipped: color.FgHiBlack,
+			statusDone:    color.FgGreen,
+		}
+		rows := [][]string{}
+		for _, item := range items {
+			text := strings.TrimSpace(item.Text)
+			if len(item.Notes) > 0 {
+				text += color.New(color.Faint).Sprintf("  # %s", item.Notes[len(item.Notes)-1].Text)
+			}
+			rows = append(rows, []string{
+				fmt.Sprint(item.ID),
+				item.Status,
+				item.ClaimedBy,
+				fmt.Sprintf("%s:%s:%d", item.Repo, item.Path, item.Line),
+				color.New(statusColors[item.Status]).Sprint(ansiURL(text, item.URL)),
+			})
+		}
+		if err := writeRows("table", []string{"ID", "STATUS", "CLAIMED BY", "LOCATION", "TEXT"}, rows); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", worklistFlags.format)
+	}
+	return nil
+}
+
+func loadWorklist(name string) (string, worklist, error) {
+	path, err := storePath("worklists", name)
+	if err != nil {
+		return "", worklist{}, err
+	}
+	var wl worklist
+	if err := loadJSON(path, &wl); errors.Is(err, errNotStored) {
+		return "", wl, fmt.Errorf("no worklist named %s", name)
+	} else if err != nil {
+		return "", wl, err
+	}
+	return path, wl, nil
+}
+
+// updateWorkItems by ID, leaving a note if one was given
+func updateWorkItems(name string, ids []string, note string, update func(*workItem)) error {
+	path, wl, err := loadWorklist(name)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return fmt.Errorf("expected a numeric item ID, got: %s", id)
+		}
+		item := wl.item(n)
+		if item == nil {
+			return fmt.Errorf("%s has no item %d", name, n)
+		}
+		if item.Status == statusDone {
+			w("item %d is already done", n)
+		}
+		update(item)
+		if note != "" {
+			item.Notes = append(item.Notes, workNote{Time: time.Now(), Text: note})
+		}
+	}
+	if err := saveJSON(path, wl); err != nil {
+		return fmt.Errorf("couldn't save %s: %w", name, err)
+	}
+	return nil
+}
+
+func (wl *worklist) item(id int) *workItem {
+	for i := range wl.Items {
+		if wl.Items[i].ID == id {
+			return &wl.Items[i]
+		}
+	}
+	return nil
+}
+
+// sync items with the current matches, keeping them sorted by location
+//
+// Still-matching items keep their status and pick up new line numbers. Items
+// that no longer match are done, and done items matching again are reopened.
+// Only a run that could have seen an item closes it: nothing is closed when
+// results were capped, and items in files that couldn't be fetched are left
+// alone. Those are returned as unseen.
+func (wl *worklist) sync(run savedRun, now time.Time) (added, closed int, unseen []workItem) {
+	// Prefer pairing with items that aren't done so reopening is a last resort
+	byKey := map[string][]int{}
+	for i, item := range wl.Items {
+		byKey[item.key()] = append(byKey[item.key()], i)
+	}
+	for _, indices := range byKey {
+		sort.SliceStable(indices, func(i, j int) bool {
+			return wl.Items[indices[i]].Status != statusDone && wl.Items[indices[j]].Status == statusDone
+		})
+	}
+
+	paired := map[int]bool{}
+	for _, m := range run.matches {
+		indices := byKey[m.key()]
+		if len(indices) == 0 {
+			wl.Items = append(wl.Items, workItem{ID: wl.NextID, Status: statusOpen, Added: now, watchMatch: m})
+			paired[len(wl.Items)-1] = true
+			wl.NextID++
+			added++
+			continue
+		}
+		i := indices[0]
+		byKey[m.key()] = indices[1:]
+		paired[i] = true
+
+		item := &wl.Items[i]
+		item.Line, item.URL, item.Text = m.Line, m.URL, m.Text
+		if item.Status == statusDone {
+			item.Status = statusOpen
+			item.ClaimedBy = ""
+			item.Closed = time.Time{}
+		}
+	}
+
+	unseen = []workItem{}
+	for i := range wl.Items {
+		item := &wl.Items[i]
+		if paired[i] || item.Status == statusDone {
+			continue
+		}
+		if run.capped || run.failed[item.file()] {
+			unseen = append(unseen, *item)
+			continue
+		}
+		item.Status = statusDone
+		item.Closed = now
+		closed++
+	}
+	sort.SliceStable(wl.Items, func(i, j int) bool {
+		a, b := wl.Items[i], wl.Items[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+	wl.Synced = now
+	return added, closed, unseen
+}
+
+func (wl *worklist) counts() map[string]int {
+	counts := map[string]int{}
+	for _, item := range wl.Items {
+		counts[item.Status]++
+	}
+	return counts
+}
+
+// worklistMarkdown is a checklist per repo, ready for an issue or a doc
+func worklistMarkdown(wl worklist, items []workItem) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\nQuery: `%s`\n", wl.Name, strings.TrimSpace(wl.Query))
+
+	var repo string
+	for _, item := range items {
+		if item.Repo != repo {
+			repo = item.Repo
+			fmt.Fprintf(&b, "\n## %s\n\n", repo)
+		}
+
+		check := " "
+		if item.Status == statusDone {
+			check = "x"
+		}
+		line := fmt.Sprintf("[%s:%d](%s) `%s`", item.Path, item.Line, item.URL, strings.ReplaceAll(strings.TrimSpace(item.Text), "`", "'"))
+		if item.Status == statusSkipped {
+			line = "~~" + line + "~~ (skipped)"
+		}
+		if item.ClaimedBy != "" && item.Status == statusClaimed {
+			line += " @" + item.ClaimedBy
+		}
+		for _, n := range item.Notes {
+			line += " — " + n.Text
+		}
+		fmt.Fprintf(&b, "- [%s] #%d %s\n", check, item.ID, line)
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorklistSync(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	wl := worklist{NextID: 1}
+	added, closed, _ := wl.sync(savedRun{matches: []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 10, Text: "OldClient()"},
+		{Repo: "a/x", Path: "main.go", Line: 20, Text: "OldClient()"},
+		{Repo: "a/y", Path: "lib.go", Line: 3, Text: "OldClient{}"},
+	}}, now)
+	if added != 3 || closed != 0 || len(wl.Items) != 3 {
+		t.Fatalf("expected 3 items added, got: +%d -%d %+v", added, closed, wl.Items)
+	}
+	wl.item(1).Status = statusClaimed
+	wl.item(1).ClaimedBy = "alice"
+	wl.item(3).Status = statusSkipped
+
+	// One of main.go's is fixed, lib.go is gone, and something new showed up
+	added, closed, _ = wl.sync(savedRun{matches: []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 12, Text: "OldClient()"},
+		{Repo: "a/z", Path: "new.go", Line: 1, Text: "OldClient()"},
+	}}, now.AddDate(0, 0, 7))
+	if added != 1 || closed != 2 {
+		t.Errorf("expected 1 added and 2 closed, got: +%d -%d", added, closed)
+	}
+
+	claimed := wl.item(1)
+	if claimed.Status != statusClaimed || claimed.ClaimedBy != "alice" || claimed.Line != 12 {
+		t.Errorf("expected the claimed item to stay claimed on its new line, got: %+v", claimed)
+	}
+	if s := wl.item(2).Status; s != statusDone {
+		t.Errorf("expected the unpaired main.go item to be done, got: %s", s)
+	}
+	if s := wl.item(3).Status; s != statusDone {
+		t.Errorf("expected the skipped item to be done once it's gone, got: %s", s)
+	}
+	if item := wl.item(4); item == nil || item.Repo != "a/z" || item.Status != statusOpen {
+		t.Errorf("expected a new open item for a/z, got: %+v", item)
+	}
+
+	// Reappearing reopens done items
+	added, _, _ = wl.sync(savedRun{matches: []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 12, Text: "OldClient()"},
+		{Repo: "a/y", Path: "lib.go", Line: 3, Text: "OldClient{}"},
+	}}, now.AddDate(0, 0, 14))
+	if added != 0 || wl.item(3).Status != statusOpen || !wl.item(3).Closed.IsZero() {
+		t.Errorf("expected lib.go's item to be reopened, got: +%d %+v", added, wl.item(3))
+	}
+
+	for i := 1; i < len(wl.Items); i++ {
+		if wl.Items[i-1].Repo > wl.Items[i].Repo {
+			t.Errorf("expected items sorted by repo, got: %s before %s", wl.Items[i-1].Repo, wl.Items[i].Repo)
+		}
+	}
+}
+
+func TestWorklistSyncIncomplete(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	matches := []watchMatch{
+		{Repo: "a/x", Path: "main.go", Line: 10, Text: "OldClient()"},
+		{Repo: "a/y", Path: "lib.go", Line: 3, Text: "OldClient{}"},
+	}
+
+	// lib.go couldn't be fetched, so only main.go's item can be closed
+	wl := worklist{NextID: 1}
+	wl.sync(savedRun{matches: matches}, now)
+	_, closed, unseen := wl.sync(savedRun{failed: map[string]bool{"a/y lib.go": true}}, now.AddDate(0, 0, 7))
+	if closed != 1 || len(unseen) != 1 || unseen[0].Path != "lib.go" {
+		t.Errorf("expected main.go closed and lib.go unseen, got: %d closed, %+v", closed, unseen)
+	}
+	if wl.item(1).Status != statusDone || wl.item(2).Status != statusOpen {
+		t.Errorf("expected only main.go's item to be done, got: %+v", wl.Items)
+	}
+
+	// Past --limit, anything could still be there
+	wl = worklist{NextID: 1}
+	wl.sync(savedRun{matches: matches}, now)
+	added, closed, unseen := wl.sync(savedRun{matches: matches[:1], capped: true}, now.AddDate(0, 0, 7))
+	if added != 0 || closed != 0 || len(unseen) != 1 || unseen[0].Path != "lib.go" {
+		t.Errorf("expected nothing closed from capped results, got: +%d -%d, %+v", added, closed, unseen)
+	}
+	if wl.item(2).Status != statusOpen {
+		t.Errorf("expected lib.go's item to stay open, got: %s", wl.item(2).Status)
+	}
+}
+
+func TestWorklistMarkdown(t *testing.T) {
+	wl := worklist{Name: "old-client", Query: "OldClient language:go "}
+	items := []workItem{
+		{ID: 1, Status: statusClaimed, ClaimedBy: "alice", watchMatch: watchMatch{Repo: "a/x", Path: "main.go", Line: 3, Text: "\tOldClient()", URL: "u1"}},
+		{ID: 2, Status: statusDone, watchMatch: watchMatch{Repo: "a/x", Path: "util.go", Line: 9, Text: "OldClient()", URL: "u2"}},
+		{ID: 3, Status: statusSkipped, Notes: []workNote{{Text: "generated"}}, watchMatch: watchMatch{Repo: "a/y", Path: "gen.go", Line: 1, Text: "`OldClient`", URL: "u3"}},
+	}
+
+	expected := "# old-client\n\nQuery: `OldClient language:go`\n" +
+		"\n## a/x\n\n" +
+		"- [ ] #1 [main.go:3](u1) `OldClient()` @alice\n" +
+		"- [x] #2 [util.go:9](u2) `OldClient()`\n" +
+		"\n## a/y\n\n" +
+		"- [ ] #3 ~~[gen.go:1](u3) `'OldClient'`~~ (skipped) — generated\n"
+	if got := worklistMarkdown(wl, items); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}